@@ -1,15 +1,12 @@
 package activitypub
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/anomalous69/fchannel/config"
 	"github.com/anomalous69/fchannel/util"
@@ -89,7 +86,8 @@ func (activity Activity) CheckValid() (Collection, bool, error) {
 		return respCollection, false, util.MakeError(err, "CheckValid")
 	}
 
-	req.Header.Set("Accept", config.ActivityStreams)
+	req.Header.Set("Accept", config.FetchAccept)
+	SignFetch(req)
 
 	resp, err := util.RouteProxy(req)
 	if err != nil {
@@ -112,18 +110,90 @@ func (activity Activity) CheckValid() (Collection, bool, error) {
 	return respCollection, false, nil
 }
 
+// Remote collections are walked at most this many pages deep.
+const maxCollectionPages = 500
+
+// GetCollection fetches a collection along with the items of all its pages.
 func (activity Activity) GetCollection() (Collection, error) {
 	var nColl Collection
+	var fetched bool
+
+	err := activity.WalkCollection(func(page Collection) error {
+		if !fetched {
+			nColl = page
+			fetched = true
+			return nil
+		}
+
+		nColl.OrderedItems = append(nColl.OrderedItems, page.OrderedItems...)
+		nColl.Items = append(nColl.Items, page.Items...)
+
+		return nil
+	})
+
+	return nColl, util.MakeError(err, "GetCollection")
+}
+
+// WalkCollection calls fn with the collection and then with each of its
+// pages in turn, so large collections never have to be held in one response.
+func (activity Activity) WalkCollection(fn func(Collection) error) error {
+	coll, err := fetchCollection(activity.Id)
 
-	req, err := http.NewRequest("GET", activity.Id, nil)
 	if err != nil {
-		return nColl, util.MakeError(err, "GetCollection")
+		return util.MakeError(err, "WalkCollection")
 	}
 
-	req.Header.Set("Accept", config.ActivityStreams)
+	// A paged collection may repeat its first page for software that does
+	// not follow first, those items come again with the page itself.
+	if coll.First != "" && coll.First != activity.Id {
+		coll.OrderedItems = nil
+		coll.Items = nil
+	}
+
+	if err := fn(coll); err != nil {
+		return util.MakeError(err, "WalkCollection")
+	}
+
+	seen := map[string]bool{activity.Id: true}
+	next := coll.First
+
+	for i := 0; next != "" && !seen[next] && i < maxCollectionPages; i++ {
+		seen[next] = true
+
+		page, err := fetchCollection(next)
+
+		if err != nil {
+			return util.MakeError(err, "WalkCollection")
+		}
+
+		if len(page.OrderedItems) == 0 && len(page.Items) == 0 {
+			break
+		}
+
+		if err := fn(page); err != nil {
+			return util.MakeError(err, "WalkCollection")
+		}
+
+		next = page.Next
+	}
+
+	return nil
+}
+
+func fetchCollection(id string) (Collection, error) {
+	var nColl Collection
+
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nColl, util.MakeError(err, "fetchCollection")
+	}
+
+	req.Header.Set("Accept", config.FetchAccept)
+	SignFetch(req)
+
 	resp, err := util.RouteProxy(req)
 	if err != nil {
-		return nColl, util.MakeError(err, "GetCollection")
+		return nColl, util.MakeError(err, "fetchCollection")
 	}
 
 	if resp.StatusCode == 200 {
@@ -132,8 +202,8 @@ func (activity Activity) GetCollection() (Collection, error) {
 		if len(body) > 0 {
 			// Try to unmarshal as Collection
 			if err := json.Unmarshal(body, &nColl); err == nil {
-				// Handle "fake" collection (single Note with empty items)
-				if nColl.Type == "Note" && len(nColl.OrderedItems) == 0 && len(nColl.Items) == 0 {
+				// Handle "fake" collection (single post with empty items)
+				if IsPostType(nColl.Type) && len(nColl.OrderedItems) == 0 && len(nColl.Items) == 0 {
 					var nObj ObjectBase
 					if err := json.Unmarshal(body, &nObj); err == nil && nObj.Type != "" {
 						nColl.Type = "Collection"
@@ -154,7 +224,7 @@ func (activity Activity) GetCollection() (Collection, error) {
 			}
 
 			// If neither, return error
-			return nColl, util.MakeError(err, "GetCollection")
+			return nColl, util.MakeError(err, "fetchCollection")
 		}
 	}
 
@@ -330,6 +400,10 @@ func (activity Activity) SetActorFollowing() (Activity, error) {
 			return activity, util.MakeError(err, "SetActorFollowing")
 		}
 
+		if err := DeleteBackfill(activity.Object.Actor, activity.Actor.Id); err != nil {
+			return activity, util.MakeError(err, "SetActorFollowing")
+		}
+
 		activity.Type = "Accept"
 		activity.Summary = activity.Object.Actor + " Unfollowing " + activity.Actor.Id
 
@@ -338,7 +412,9 @@ func (activity Activity) SetActorFollowing() (Activity, error) {
 
 	if !alreadyFollowing && !alreadyFollower {
 		if res, _ := activity.Actor.IsLocal(); !res {
-			go activity.Actor.WriteCache()
+			if err := EnqueueBackfill(activity.Object.Actor, activity.Actor.Id); err != nil {
+				return activity, util.MakeError(err, "SetActorFollowing")
+			}
 		}
 
 		query = `insert into following (id, following) values ($1, $2)`
@@ -355,124 +431,108 @@ func (activity Activity) SetActorFollowing() (Activity, error) {
 	return activity, nil
 }
 
+// MakeRequestInbox queues the activity for every recipient, recipients that
+// share an inbox on the same instance only get it once.
 func (activity Activity) MakeRequestInbox() error {
 	j, _ := json.MarshalIndent(activity, "", "\t")
 
-	for _, e := range activity.To {
-		if e != activity.Actor.Id {
-			actor := Actor{Id: e, Inbox: e + "/inbox"}
+	err := DeliverToInboxes(activity.Actor.Id, activity.To, j)
 
-			preferedusername, instance := GetActorAndInstance(actor.Id)
-
-			if preferedusername != "main" && !actor.HasOption(OptionReadOnly) {
-				go func(actor Actor, activity Activity) error {
-					var status int
-					var try int
-
-					for try != 5 && status != 200 {
-						time.Sleep(time.Duration(try) * time.Minute)
-
-						req, err := http.NewRequest("POST", actor.Inbox, bytes.NewBuffer(j))
-						if err != nil {
-							return util.MakeError(err, "MakeRequest")
-						}
+	return util.MakeError(err, "MakeRequestInbox")
+}
 
-						date := time.Now().UTC().Format(time.RFC1123)
-						path := strings.Replace(actor.Inbox, instance, "", 1)
-						re := regexp.MustCompile("https?://(www.)?")
-						path = re.ReplaceAllString(path, "")
-						sig := fmt.Sprintf("(request-target): %s %s\nhost: %s\ndate: %s", "post", path, instance, date)
-						encSig, err := activity.Actor.ActivitySign(sig)
+func DeliverToInboxes(from string, to []string, payload []byte) error {
+	sent := make(map[string]bool)
 
-						if err != nil {
-							return util.MakeError(err, "MakeRequest")
-						}
+	for _, e := range to {
+		if e != from {
+			actor := Actor{Id: e, Inbox: e + "/inbox"}
 
-						signature := fmt.Sprintf("keyId=\"%s\",headers=\"(request-target) host date\",signature=\"%s\"", activity.Actor.PublicKey.Id, encSig)
+			preferedusername, _ := GetActorAndInstance(actor.Id)
 
-						req.Header.Set("Content-Type", config.ActivityStreams)
-						req.Header.Set("Date", date)
-						req.Header.Set("Signature", signature)
-						req.Host = instance
+			if preferedusername != "main" && !actor.HasOption(OptionReadOnly) && util.IsInstanceAllowed(e) {
+				inbox, ok := GetDeliveryInbox(e)
 
-						resp, err := util.RouteProxy(req)
+				// Fetching an unknown actor here would hold up the poster,
+				// the delivery worker looks up its inbox instead
+				if !ok {
+					if err := EnqueueUnresolvedDelivery(from, e, payload); err != nil {
+						return util.MakeError(err, "DeliverToInboxes")
+					}
 
-						if err != nil {
-							try += 1
-							continue
-						}
+					continue
+				}
 
-						status = resp.StatusCode
-						try += 1
-					}
+				if sent[inbox] {
+					continue
+				}
 
-					return nil
+				if err := EnqueueDelivery(from, inbox, payload); err != nil {
+					return util.MakeError(err, "DeliverToInboxes")
+				}
 
-				}(actor, activity)
+				sent[inbox] = true
 			}
 		}
-
-		time.Sleep(150 * time.Millisecond)
 	}
 
 	return nil
 }
 
-func (activity Activity) MakeRequestOutbox() error {
-	j, _ := json.Marshal(activity)
-
-	if activity.Actor.Outbox == "" {
-		return util.MakeError(errors.New("invalid outbox"), "MakeRequestOutbox")
+// GetDeliveryInbox returns the inbox to deliver to for an actor id, its
+// instance's shared inbox when it publishes one. Only cached actors are
+// looked at, false means the actor has not been fetched yet.
+func GetDeliveryInbox(id string) (string, bool) {
+	if strings.HasPrefix(id, config.Domain+"/") {
+		return config.Domain + "/inbox", true
 	}
 
-	go func(actor Actor, activity Activity) error {
-		var status int
-		var try int
-
-		for try != 5 && status != 200 {
-			time.Sleep(time.Duration(try) * time.Minute)
-
-			req, err := http.NewRequest("POST", activity.Actor.Outbox, bytes.NewBuffer(j))
+	name, instance := GetActorAndInstance(id)
+	actor, ok := GetCachedActor(name + "@" + instance)
 
-			if err != nil {
-				return util.MakeError(err, "MakeRequestOutbox")
-			}
+	if !ok {
+		return id + "/inbox", false
+	}
 
-			re := regexp.MustCompile("https?://(www.)?")
+	return actor.DeliveryInbox(), true
+}
 
-			_, instance := GetActorAndInstance(activity.Actor.Id)
+// ResolveDeliveryInbox is GetDeliveryInbox for the delivery workers, an actor
+// that is not cached is fetched.
+func ResolveDeliveryInbox(id string) string {
+	if inbox, ok := GetDeliveryInbox(id); ok {
+		return inbox
+	}
 
-			date := time.Now().UTC().Format(time.RFC1123)
-			path := strings.Replace(activity.Actor.Outbox, instance, "", 1)
-			path = re.ReplaceAllString(path, "")
-			sig := fmt.Sprintf("(request-target): %s %s\nhost: %s\ndate: %s", "post", path, instance, date)
-			encSig, err := activity.Actor.ActivitySign(sig)
+	actor, err := GetActor(id)
 
-			if err != nil {
-				return util.MakeError(err, "MakeRequestOutbox")
-			}
+	if err != nil || actor.Id == "" {
+		return id + "/inbox"
+	}
 
-			signature := fmt.Sprintf("keyId=\"%s\",headers=\"(request-target) host date\",signature=\"%s\"", activity.Actor.PublicKey.Id, encSig)
+	return actor.DeliveryInbox()
+}
 
-			req.Header.Set("Content-Type", config.ActivityStreams)
-			req.Header.Set("Date", date)
-			req.Header.Set("Signature", signature)
-			req.Host = instance
+func (actor Actor) DeliveryInbox() string {
+	if actor.Endpoints != nil && actor.Endpoints.SharedInbox != "" {
+		return actor.Endpoints.SharedInbox
+	}
 
-			resp, err := util.RouteProxy(req)
+	if actor.Inbox != "" {
+		return actor.Inbox
+	}
 
-			if err != nil {
-				try += 1
-				continue
-			}
+	return actor.Id + "/inbox"
+}
 
-			status = resp.StatusCode
-			try += 1
-		}
+func (activity Activity) MakeRequestOutbox() error {
+	j, _ := json.Marshal(activity)
 
-		return nil
+	if activity.Actor.Outbox == "" {
+		return util.MakeError(errors.New("invalid outbox"), "MakeRequestOutbox")
+	}
 
-	}(*activity.Actor, activity)
+	err := EnqueueDelivery(activity.Actor.Id, activity.Actor.Outbox, j)
 
-	return util.MakeError(nil, "MakeRequestOutbox")
+	return util.MakeError(err, "MakeRequestOutbox")
 }
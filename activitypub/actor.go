@@ -1,20 +1,16 @@
 package activitypub
 
 import (
-	"crypto"
-	crand "crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/anomalous69/fchannel/config"
@@ -22,7 +18,12 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// Threads per outbox page, each is sent along with all of its replies.
+const OutboxPageSize = 10
+
 var ActorCache = make(map[string]Actor)
+var actorCacheFetched = make(map[string]time.Time)
+var actorCacheMutex sync.RWMutex
 
 func (actor Actor) AddFollower(follower string) error {
 	query := `insert into follower (id, follower) values ($1, $2)`
@@ -31,45 +32,41 @@ func (actor Actor) AddFollower(follower string) error {
 }
 
 func (actor Actor) ActivitySign(signature string) (string, error) {
-	if actor.PublicKey.Id == "" {
+	if actor.PublicKey == nil || actor.PublicKey.Id == "" {
 		actor, _ = GetActorFromDB(actor.Id)
 	}
 
-	var file string
-
-	query := `select file from publicKeyPem where id=$1 `
-	if err := config.DB.QueryRow(query, actor.PublicKey.Id).Scan(&file); err != nil {
-		return "", util.MakeError(err, "ActivitySign")
+	if actor.PublicKey == nil {
+		return "", util.MakeError(errors.New("actor has no public key"), "ActivitySign")
 	}
 
-	file = strings.ReplaceAll(file, "public.pem", "private.pem")
+	sig, _, err := actor.SignWithKey(actor.PublicKey.Id, []byte(signature))
 
-	_, err := os.Stat(file)
 	if err != nil {
-		config.Log.Println(`\n Unable to locate private key. Now,
-this means that you are now missing the proof that you are the
-owner of the "` + actor.PreferredUsername + `" board. If you are the developer,
-then your job is just as easy as generating a new keypair, but
-if this board is live, then you'll also have to convince the other
-owners to switch their public keys for you so that they will start
-accepting your posts from your board from this site. Good luck ;)`)
 		return "", util.MakeError(err, "ActivitySign")
 	}
 
-	var publickey []byte
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
 
-	if publickey, err = os.ReadFile(file); err != nil {
-		return "", util.MakeError(err, "ActivitySign")
+// SignWithKey signs data with one of the actors keys and returns the
+// signature along with the RFC 9421 name of the algorithm used.
+func (actor Actor) SignWithKey(keyId string, data []byte) ([]byte, string, error) {
+	key, err := GetPrivateKey(keyId)
+
+	if err != nil {
+		config.Log.Println(`\n Unable to locate private key. Now,
+this means that you are now missing the proof that you are the
+owner of the "` + actor.PreferredUsername + `" board. Rotate the key
+from the board's manage page, instances that refetch the board
+will pick up the new key. Ones that don't will keep rejecting
+your posts until their owners update it by hand.`)
+		return nil, "", util.MakeError(err, "SignWithKey")
 	}
 
-	block, _ := pem.Decode(publickey)
-	pub, _ := x509.ParsePKCS1PrivateKey(block.Bytes)
-	rng := crand.Reader
-	hashed := sha256.New()
-	hashed.Write([]byte(signature))
-	cipher, _ := rsa.SignPKCS1v15(rng, pub, crypto.SHA256, hashed.Sum(nil))
+	sig, algorithm, err := SignData(key, data)
 
-	return base64.StdEncoding.EncodeToString(cipher), nil
+	return sig, algorithm, util.MakeError(err, "SignWithKey")
 }
 
 func (actor Actor) ArchivePosts() error {
@@ -222,7 +219,11 @@ func (actor Actor) GetCatalogCollection() (Collection, error) {
 		union
 		select id, name, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor in (select following from following where id=$1) and id in (select id from replies where inreplyto='') and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
 		union
-		select id, name, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where actor in (select following from following where id=$1) and id in (select id from replies where inreplyto='') and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
+		select id, name, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where (actor=$1 or actor in (select following from following where id=$1)) and id in (select id from replies where inreplyto='') and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
+		union
+		select id, name, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where id in (select object from crosspost where board=$1) and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
+		union
+		select id, name, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where id in (select object from crosspost where board=$1) and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
 	) as x order by x.updated desc limit 165`
 
 	if rows, err = config.DB.Query(query, actor.Id); err != nil {
@@ -295,7 +296,11 @@ func (actor Actor) GetCollectionPage(page int) (Collection, error) {
 		union
 		select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor in (select following from following where id=$1) and id in (select id from replies where inreplyto='') and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
 		union
-		select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where id not in (select activity_id from sticky where actor_id=$1) and actor in (select following from following where id=$1) and id in (select id from replies where inreplyto='') and type='Note'
+		select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where id not in (select activity_id from sticky where actor_id=$1) and (actor=$1 or actor in (select following from following where id=$1)) and id in (select id from replies where inreplyto='') and type='Note'
+		union
+		select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where id in (select object from crosspost where board=$1) and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
+		union
+		select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from cacheactivitystream where id in (select object from crosspost where board=$1) and type='Note' and id not in (select activity_id from sticky where actor_id=$1)
 	) as x order by x.updated desc limit $2 offset $3`
 
 	limit := 15
@@ -370,11 +375,49 @@ func (actor Actor) GetCollectionPage(page int) (Collection, error) {
 }
 
 func (actor Actor) GetCollection() (Collection, error) {
+	query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' order by updated desc`
+	return actor.getCollection(query, actor.Id)
+}
+
+// GetOutboxPage returns one page of the outbox, newest thread first by when
+// it was made so bumps do not move threads between pages while a peer walks
+// them. before is the id of the last thread of the previous page, empty for
+// the first page.
+func (actor Actor) GetOutboxPage(before string) (Collection, error) {
+	if before == "" {
+		query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' order by published desc, id desc limit $2`
+		return actor.getCollection(query, actor.Id, OutboxPageSize)
+	}
+
+	query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' and (published, id) < (select published, id from activitystream where id=$3) order by published desc, id desc limit $2`
+	return actor.getCollection(query, actor.Id, OutboxPageSize, before)
+}
+
+// GetOutboxPageAfter returns the page of threads made just after the one
+// with the id after, newest first like the other pages. With after empty it
+// is the last page, the oldest threads.
+func (actor Actor) GetOutboxPageAfter(after string) (Collection, error) {
+	var page Collection
+	var err error
+
+	if after == "" {
+		query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' order by published asc, id asc limit $2`
+		page, err = actor.getCollection(query, actor.Id, OutboxPageSize)
+	} else {
+		query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' and (published, id) > (select published, id from activitystream where id=$3) order by published asc, id asc limit $2`
+		page, err = actor.getCollection(query, actor.Id, OutboxPageSize, after)
+	}
+
+	slices.Reverse(page.OrderedItems)
+
+	return page, err
+}
+
+func (actor Actor) getCollection(query string, args ...interface{}) (Collection, error) {
 	var nColl Collection
 	var result []ObjectBase
 
-	query := `select id, name, alias, content, type, published, updated, attributedto, attachment, preview, actor, tripcode, sensitive from activitystream where actor=$1 and id in (select id from replies where inreplyto='') and type='Note' order by updated desc`
-	rows, err := config.DB.Query(query, actor.Id)
+	rows, err := config.DB.Query(query, args...)
 
 	if err != nil {
 		return nColl, util.MakeError(err, "GetCollection")
@@ -569,6 +612,22 @@ func (actor Actor) IsFollowing(following string) (bool, error) {
 	return true, nil
 }
 
+func (actor Actor) RemoveFollower(follower string) error {
+	query := `delete from follower where id=$1 and follower=$2`
+	_, err := config.DB.Exec(query, actor.Id, follower)
+
+	return util.MakeError(err, "RemoveFollower")
+}
+
+func (actor Actor) RemoveFollowing(following string) error {
+	query := `delete from following where id=$1 and following=$2`
+	if _, err := config.DB.Exec(query, actor.Id, following); err != nil {
+		return util.MakeError(err, "RemoveFollowing")
+	}
+
+	return util.MakeError(DeleteBackfill(actor.Id, following), "RemoveFollowing")
+}
+
 func (actor Actor) GetFollower() ([]ObjectBase, error) {
 	var followerCollection []ObjectBase
 
@@ -721,7 +780,13 @@ func (actor Actor) GetImgTotal() (int, error) {
 }
 
 func (actor Actor) GetInfoResp(ctx *fiber.Ctx) error {
-	enc, _ := json.MarshalIndent(actor, "", "\t")
+	actor.AssertionMethod, _ = actor.GetMultikeys()
+	actor.Endpoints = &Endpoints{SharedInbox: config.Domain + "/inbox"}
+
+	enc, _ := json.MarshalIndent(struct {
+		Context interface{} `json:"@context"`
+		Actor
+	}{ActorContext, actor}, "", "\t")
 	ctx.Response().Header.Set("Content-Type", "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\"")
 
 	_, err := ctx.Write(enc)
@@ -740,29 +805,82 @@ func (actor Actor) GetPostTotal() (int, error) {
 	return count, nil
 }
 
+// GetOutbox answers with the outbox as an OrderedCollection linking to its
+// first and last pages, or with the page asked for by ?page=, ?before= or
+// ?after=. Pages link to the older threads as next and the newer ones as
+// prev. The collection repeats the first page's threads, older FChannel
+// instances only read orderedItems from it.
 func (actor Actor) GetOutbox(ctx *fiber.Ctx) error {
 	var collection Collection
 
-	c, err := actor.GetCollection()
-
-	if err != nil {
-		return util.MakeError(err, "GetOutbox")
-	}
-
-	collection.OrderedItems = c.OrderedItems
 	collection.AtContext.Context = "https://www.w3.org/ns/activitystreams"
 	collection.Actor = &actor
 
-	collection.TotalItems, err = actor.GetPostTotal()
+	before := ctx.Query("before")
+	after := ctx.Query("after")
+	last := after == "" && ctx.Query("page") == "last"
+
+	var page Collection
+	var err error
+
+	if after != "" || last {
+		page, err = actor.GetOutboxPageAfter(after)
+	} else {
+		page, err = actor.GetOutboxPage(before)
+	}
 
 	if err != nil {
 		return util.MakeError(err, "GetOutbox")
 	}
 
-	collection.TotalImgs, err = actor.GetImgTotal()
+	collection.OrderedItems = page.OrderedItems
 
-	if err != nil {
-		return util.MakeError(err, "GetOutbox")
+	if before != "" || after != "" || ctx.Query("page") != "" {
+		collection.Type = "OrderedCollectionPage"
+		collection.PartOf = actor.Outbox
+
+		n := len(page.OrderedItems)
+		full := n == OutboxPageSize
+
+		switch {
+		case after != "":
+			collection.Id = actor.Outbox + "?after=" + url.QueryEscape(after)
+		case before != "":
+			collection.Id = actor.Outbox + "?before=" + url.QueryEscape(before)
+		case last:
+			collection.Id = actor.Outbox + "?page=last"
+		default:
+			collection.Id = actor.Outbox + "?page=1"
+		}
+
+		// The cursor of an ?after= page is older than it, other pages only
+		// have more after them when they are full
+		if n > 0 && (after != "" || (!last && full)) {
+			collection.Next = actor.Outbox + "?before=" + url.QueryEscape(page.OrderedItems[n-1].Id)
+		}
+
+		// The cursor of a ?before= page is newer than it, other pages only
+		// have more before them when they are full
+		if n > 0 && (before != "" || ((after != "" || last) && full)) {
+			collection.Prev = actor.Outbox + "?after=" + url.QueryEscape(page.OrderedItems[0].Id)
+		}
+	} else {
+		collection.Id = actor.Outbox
+		collection.Type = "OrderedCollection"
+		collection.First = actor.Outbox + "?page=1"
+		collection.Last = actor.Outbox + "?page=last"
+
+		collection.TotalItems, err = actor.GetPostTotal()
+
+		if err != nil {
+			return util.MakeError(err, "GetOutbox")
+		}
+
+		collection.TotalImgs, err = actor.GetImgTotal()
+
+		if err != nil {
+			return util.MakeError(err, "GetOutbox")
+		}
 	}
 
 	enc, _ := json.Marshal(collection)
@@ -1029,25 +1147,24 @@ func (actor Actor) Verify(signature string, verify string) error {
 		actor = _actor
 	}
 
-	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	pub, err := ParsePublicKeyPem(actor.PublicKey.PublicKeyPem)
 
 	if err != nil {
 		return util.MakeError(err, "Verify")
 	}
 
-	hashed := sha256.New()
-	hashed.Write([]byte(verify))
-
-	return rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, hashed.Sum(nil), sig)
+	return VerifySignature(pub, "", sig, []byte(verify))
 }
 
 func (actor Actor) VerifyHeaderSignature(ctx *fiber.Ctx) bool {
-	if actor.PublicKey == nil || actor.PublicKey.Id == "" {
+	if actor.Id == "" {
 		return false
 	}
 
+	if ctx.Get("Signature-Input") != "" {
+		return actor.VerifyMessageSignature(ctx) == nil
+	}
+
 	var sig string
 	var path string
 	var host string
@@ -1067,7 +1184,7 @@ func (actor Actor) VerifyHeaderSignature(ctx *fiber.Ctx) bool {
 		switch e {
 		case "(request-target)":
 			method = strings.ToLower(ctx.Method())
-			path = ctx.Path()
+			path = string(ctx.Request().URI().RequestURI())
 			sig += "(request-target): " + method + " " + path + "" + nl
 		case "host":
 			host = ctx.Hostname()
@@ -1084,21 +1201,32 @@ func (actor Actor) VerifyHeaderSignature(ctx *fiber.Ctx) bool {
 		}
 	}
 
-	if s.KeyId != actor.PublicKey.Id {
+	key, err := actor.GetVerificationKey(s.KeyId)
+
+	if err != nil {
 		return false
 	}
 
+	// The signature only covers the body through the digest, so without it
+	// a captured request could be replayed with a different activity.
+	if ctx.Method() != "GET" && ctx.Method() != "HEAD" {
+		if !util.IsInStringArray(s.Headers, "digest") || !VerifyDigest(digest, ctx.Body()) {
+			return false
+		}
+	}
+
 	t, _ := time.Parse(time.RFC1123, date)
 
-	if time.Now().UTC().Sub(t).Seconds() > 75 {
+	// Dates ahead of us are held to the same window, or a request dated in
+	// the future could be replayed until long after the seen signatures are
+	// pruned.
+	if d := time.Now().UTC().Sub(t); d > signatureMaxAge || d < -signatureMaxAge {
 		return false
 	}
 
-	if actor.Verify(s.Signature, sig) != nil {
-		return false
-	}
+	signature, _ := base64.StdEncoding.DecodeString(s.Signature)
 
-	return true
+	return VerifySignature(key, s.Algorithm, signature, []byte(sig)) == nil
 }
 
 func (actor Actor) WriteCache() error {
@@ -1109,19 +1237,17 @@ func (actor Actor) WriteCache() error {
 	}
 
 	reqActivity := Activity{Id: actor.Outbox}
-	collection, err := reqActivity.GetCollection()
-
-	if err != nil {
-		return util.MakeError(err, "WriteCache")
-	}
-
-	for _, e := range collection.OrderedItems {
-		if _, err := e.WriteCache(); err != nil {
-			return util.MakeError(err, "WriteCache")
+	err = reqActivity.WalkCollection(func(page Collection) error {
+		for _, e := range page.OrderedItems {
+			if _, err := e.WriteCache(); err != nil {
+				return err
+			}
 		}
-	}
 
-	return nil
+		return nil
+	})
+
+	return util.MakeError(err, "WriteCache")
 }
 
 func (actor Actor) MakeFollowActivity(follow string) (Activity, error) {
@@ -1147,12 +1273,94 @@ func (actor Actor) MakeFollowActivity(follow string) (Activity, error) {
 	followActivity.Actor = &nactor
 	followActivity.Object = obj
 
+	// Other software looks for the followed actor in the object's id
+	followActivity.Object.Id = follow
 	followActivity.Object.Actor = follow
 	followActivity.To = append(followActivity.To, follow)
 
 	return followActivity, nil
 }
 
+// UpdateActorRequest sends the actor document to every instance it federates
+// with, so they pick up a new key without waiting for their cache to expire.
+func (actor Actor) UpdateActorRequest() error {
+	nActor, err := GetActorFromDB(actor.Id)
+
+	if err != nil {
+		return util.MakeError(err, "UpdateActorRequest")
+	}
+
+	nActor.AssertionMethod, _ = nActor.GetMultikeys()
+	nActor.Endpoints = &Endpoints{SharedInbox: config.Domain + "/inbox"}
+
+	var to []string
+
+	followers, err := nActor.GetFollower()
+
+	if err != nil {
+		return util.MakeError(err, "UpdateActorRequest")
+	}
+
+	following, err := nActor.GetFollowing()
+
+	if err != nil {
+		return util.MakeError(err, "UpdateActorRequest")
+	}
+
+	for _, e := range append(followers, following...) {
+		if !util.IsInStringArray(to, e.Id) {
+			to = append(to, e.Id)
+		}
+	}
+
+	// Activity can only carry an ObjectBase, the object here is the whole
+	// actor including its keys.
+	update := struct {
+		AtContext
+		Type      string    `json:"type"`
+		Actor     *Actor    `json:"actor"`
+		To        []string  `json:"to,omitempty"`
+		Published time.Time `json:"published"`
+		Object    *Actor    `json:"object"`
+	}{
+		AtContext: AtContext{Context: "https://www.w3.org/ns/activitystreams"},
+		Type:      "Update",
+		Actor:     &nActor,
+		To:        to,
+		Published: time.Now().UTC(),
+		Object:    &nActor,
+	}
+
+	j, _ := json.MarshalIndent(update, "", "\t")
+
+	err = DeliverToInboxes(nActor.Id, to, j)
+
+	return util.MakeError(err, "UpdateActorRequest")
+}
+
+// MakeUndoFollowActivity withdraws a follow of the given actor, the Follow is
+// rebuilt since we don't keep the original activity around.
+func (actor Actor) MakeUndoFollowActivity(follow string) (Activity, error) {
+	var undoActivity Activity
+
+	followActivity, err := actor.MakeFollowActivity(follow)
+
+	if err != nil {
+		return undoActivity, util.MakeError(err, "MakeUndoFollowActivity")
+	}
+
+	undoActivity.AtContext.Context = "https://www.w3.org/ns/activitystreams"
+	undoActivity.Type = "Undo"
+	undoActivity.Actor = followActivity.Actor
+	undoActivity.To = followActivity.To
+
+	undoActivity.Object.Type = "Follow"
+	undoActivity.Object.Actor = followActivity.Actor.Id
+	undoActivity.Object.Object = &NestedObjectBase{Id: follow}
+
+	return undoActivity, nil
+}
+
 func (actor Actor) WantToServePage(page int) (Collection, error) {
 	var collection Collection
 	var err error
@@ -1241,6 +1449,10 @@ func (actor Actor) GetJanitors() ([]util.Verify, error) {
 func (actor Actor) ProcessInboxCreate(activity Activity) error {
 	if local, _ := actor.IsLocal(); local {
 		if local, _ := activity.Actor.IsLocal(); !local {
+			if !activity.Actor.IsBoard() {
+				return actor.ProcessPersonCreate(activity)
+			}
+
 			isFollowing, err := actor.IsFollowing(activity.Actor.Id)
 			if err != nil {
 				return util.MakeError(err, "ActorInbox")
@@ -1494,6 +1706,10 @@ const (
 	OptionTripcode  = 1 << 2 // 4
 	OptionAnonymous = 1 << 3 // 8
 	OptionReadOnly  = 1 << 4 // 16
+	// Accept replies from user accounts on microblog software
+	OptionUserReplies = 1 << 5 // 32
+	// Publish new threads to the relays the instance subscribes to
+	OptionRelay = 1 << 6 // 64
 )
 
 // HasOption returns true if the actor's OptionsMask contains the given option bit(s)
@@ -0,0 +1,249 @@
+package activitypub
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+const (
+	BackfillPending = "pending"
+	BackfillRunning = "running"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+)
+
+const backfillPollInterval = 10 * time.Second
+
+// A page failing this many times in a row fails the job.
+const backfillMaxFailures = 5
+
+var backfillWake = make(chan struct{}, 1)
+
+// Last request made to each instance by the backfill worker.
+var backfillRequests = make(map[string]time.Time)
+var backfillRequestsMutex sync.Mutex
+
+type Backfill struct {
+	Id        int
+	Actor     string
+	Target    string
+	Page      string
+	Threads   int
+	Status    string
+	LastError string
+	Failures  int
+	Created   time.Time
+	Updated   time.Time
+}
+
+// EnqueueBackfill schedules importing the outbox of target for the local
+// board actor, starting over if the board was followed before.
+func EnqueueBackfill(actor string, target string) error {
+	query := `insert into backfill (actor, target) values ($1, $2) on conflict (actor, target) do update set page='', threads=0, status=$3, last_error='', failures=0, updated=NOW()`
+	if _, err := config.DB.Exec(query, actor, target, BackfillPending); err != nil {
+		return util.MakeError(err, "EnqueueBackfill")
+	}
+
+	select {
+	case backfillWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func DeleteBackfill(actor string, target string) error {
+	query := `delete from backfill where actor=$1 and target=$2`
+	_, err := config.DB.Exec(query, actor, target)
+
+	return util.MakeError(err, "DeleteBackfill")
+}
+
+func (actor Actor) GetBackfills() ([]Backfill, error) {
+	var backfills []Backfill
+
+	query := `select id, actor, target, page, threads, status, last_error, failures, created, updated from backfill where actor=$1 order by created desc`
+	rows, err := config.DB.Query(query, actor.Id)
+
+	if err != nil {
+		return backfills, util.MakeError(err, "GetBackfills")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var backfill Backfill
+
+		if err := rows.Scan(&backfill.Id, &backfill.Actor, &backfill.Target, &backfill.Page, &backfill.Threads, &backfill.Status, &backfill.LastError, &backfill.Failures, &backfill.Created, &backfill.Updated); err != nil {
+			return backfills, util.MakeError(err, "GetBackfills")
+		}
+
+		backfills = append(backfills, backfill)
+	}
+
+	return backfills, nil
+}
+
+// StartBackfillWorker works through backfill jobs one page at a time. Jobs
+// left running by a restart carry on from the page they had reached.
+func StartBackfillWorker() {
+	for {
+		backfill, ok, err := nextBackfill()
+
+		if err != nil {
+			config.Log.Println(err)
+		}
+
+		if !ok {
+			select {
+			case <-backfillWake:
+			case <-time.After(backfillPollInterval):
+			}
+			continue
+		}
+
+		if err := backfill.Step(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+}
+
+// nextBackfill picks the job that has waited longest, so several follows are
+// imported side by side rather than one after another.
+func nextBackfill() (Backfill, bool, error) {
+	var backfill Backfill
+
+	query := `select id, actor, target, page, threads, status, last_error, failures, created, updated from backfill where status in ($1, $2) order by updated limit 1`
+	err := config.DB.QueryRow(query, BackfillPending, BackfillRunning).Scan(&backfill.Id, &backfill.Actor, &backfill.Target, &backfill.Page, &backfill.Threads, &backfill.Status, &backfill.LastError, &backfill.Failures, &backfill.Created, &backfill.Updated)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return backfill, false, nil
+	} else if err != nil {
+		return backfill, false, util.MakeError(err, "nextBackfill")
+	}
+
+	return backfill, true, nil
+}
+
+// Step fetches and caches the next page of the job, then records where to
+// continue from.
+func (backfill Backfill) Step() error {
+	if !util.IsInstanceAllowed(backfill.Target) {
+		return backfill.save(BackfillFailed, "instance is not federated with")
+	}
+
+	id := backfill.Page
+
+	if id == "" {
+		target, err := GetActor(backfill.Target)
+
+		if err != nil || target.Outbox == "" {
+			return backfill.fail(errors.New("could not get outbox of " + backfill.Target))
+		}
+
+		id = target.Outbox
+	}
+
+	waitForInstance(id)
+
+	page, err := fetchCollection(id)
+
+	if err != nil {
+		return backfill.fail(err)
+	}
+
+	// The outbox itself only links to its first page, and may repeat that
+	// page's threads for older software. Software without pages sends every
+	// thread at once.
+	paged := page.First != "" && page.First != id
+
+	if paged {
+		page.OrderedItems = nil
+	}
+
+	for _, e := range page.OrderedItems {
+		if !backfill.wants(e) {
+			return backfill.save(BackfillDone, "")
+		}
+
+		if _, err := e.WriteCache(); err != nil {
+			config.Log.Println(util.MakeError(err, "Step"))
+		}
+
+		backfill.Threads++
+	}
+
+	next := page.Next
+
+	if paged {
+		next = page.First
+	}
+
+	if next == "" || next == id {
+		return backfill.save(BackfillDone, "")
+	}
+
+	backfill.Page = next
+
+	return backfill.save(BackfillRunning, "")
+}
+
+// wants reports whether a thread is still within the configured depth.
+func (backfill Backfill) wants(obj ObjectBase) bool {
+	if config.BackfillThreads > 0 && backfill.Threads >= config.BackfillThreads {
+		return false
+	}
+
+	// Outboxes are ordered by when threads were made, not by bumps
+	if config.BackfillDays > 0 {
+		if time.Since(obj.Published) > time.Duration(config.BackfillDays)*24*time.Hour {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (backfill Backfill) save(status string, lastError string) error {
+	query := `update backfill set page=$1, threads=$2, status=$3, last_error=$4, failures=0, updated=NOW() where id=$5`
+	_, err := config.DB.Exec(query, backfill.Page, backfill.Threads, status, lastError, backfill.Id)
+
+	return util.MakeError(err, "save")
+}
+
+// fail keeps the job on the same page to try again later, until it has
+// failed too often in a row.
+func (backfill Backfill) fail(err error) error {
+	status := BackfillRunning
+
+	if backfill.Failures+1 >= backfillMaxFailures {
+		status = BackfillFailed
+	}
+
+	query := `update backfill set status=$1, last_error=$2, failures=failures+1, updated=NOW() where id=$3`
+	if _, dberr := config.DB.Exec(query, status, err.Error(), backfill.Id); dberr != nil {
+		return util.MakeError(dberr, "fail")
+	}
+
+	return util.MakeError(err, "Step")
+}
+
+// waitForInstance sleeps until backfilldelay has passed since the last
+// backfill request to the same instance.
+func waitForInstance(id string) {
+	host := util.GetInstanceHost(id)
+	delay := time.Duration(config.BackfillDelay) * time.Second
+
+	backfillRequestsMutex.Lock()
+	wait := time.Until(backfillRequests[host].Add(delay))
+	backfillRequests[host] = time.Now().Add(max(wait, 0))
+	backfillRequestsMutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
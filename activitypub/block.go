@@ -0,0 +1,104 @@
+package activitypub
+
+import (
+	"encoding/json"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// BlockInstance drops every follow between our boards and actors on a newly
+// blocked instance. With federate set each of those actors is also sent a
+// Block, which the delivery queue still lets through to a blocked instance.
+func BlockInstance(federate bool) error {
+	type follow struct {
+		local  string
+		remote string
+	}
+
+	var follows []follow
+
+	query := `select id, follower from follower union select id, following from following`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return util.MakeError(err, "BlockInstance")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var e follow
+
+		if err := rows.Scan(&e.local, &e.remote); err != nil {
+			return util.MakeError(err, "BlockInstance")
+		}
+
+		if util.IsInstanceBlocked(e.remote) {
+			follows = append(follows, e)
+		}
+	}
+
+	for _, e := range follows {
+		actor := Actor{Id: e.local}
+
+		if err := actor.RemoveFollower(e.remote); err != nil {
+			return util.MakeError(err, "BlockInstance")
+		}
+
+		if err := actor.RemoveFollowing(e.remote); err != nil {
+			return util.MakeError(err, "BlockInstance")
+		}
+
+		if federate {
+			if err := SendBlock(e.local, e.remote); err != nil {
+				return util.MakeError(err, "BlockInstance")
+			}
+		}
+	}
+
+	return RefreshBoards()
+}
+
+func SendBlock(local string, remote string) error {
+	actor, err := GetActorFromDB(local)
+
+	if err != nil {
+		return util.MakeError(err, "SendBlock")
+	}
+
+	var activity Activity
+
+	activity.AtContext.Context = "https://www.w3.org/ns/activitystreams"
+	activity.Type = "Block"
+	activity.Actor = &actor
+	activity.To = []string{remote}
+	activity.Object.Id = remote
+
+	j, _ := json.MarshalIndent(activity, "", "\t")
+
+	err = EnqueueDelivery(actor.Id, remote+"/inbox", j)
+
+	return util.MakeError(err, "SendBlock")
+}
+
+// ProcessBlock ends the follows between a remote actor and the local board it
+// blocked.
+func (activity Activity) ProcessBlock() error {
+	actor := Actor{Id: activity.Object.Id}
+
+	if local, _ := actor.IsLocal(); !local {
+		return nil
+	}
+
+	config.Log.Println(activity.Actor.Id + " blocked " + actor.Id)
+
+	if err := actor.RemoveFollower(activity.Actor.Id); err != nil {
+		return util.MakeError(err, "ProcessBlock")
+	}
+
+	if err := actor.RemoveFollowing(activity.Actor.Id); err != nil {
+		return util.MakeError(err, "ProcessBlock")
+	}
+
+	return RefreshBoards()
+}
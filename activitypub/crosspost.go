@@ -0,0 +1,254 @@
+package activitypub
+
+import (
+	"database/sql"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// A Crosspost shows a thread from the Origin board on Board. The Announcer
+// is Board itself when a mod cross-posted it there, or the followed board
+// whose Announce brought it in.
+type Crosspost struct {
+	Board     string
+	Object    string
+	Origin    string
+	Announcer string
+	Activity  string
+	Created   time.Time
+}
+
+// OriginName names the board the thread is from the way the board list
+// does, with the instance for remote boards.
+func (crosspost Crosspost) OriginName() string {
+	name := "/" + path.Base(crosspost.Origin) + "/"
+
+	if host := util.GetInstanceHost(crosspost.Origin); host != util.GetInstanceHost(config.Domain) {
+		name += "@" + host
+	}
+
+	return name
+}
+
+// Crosspost shows a thread from another board on this one and announces it
+// to the board's followers. Remote threads are cached first.
+func (actor Actor) Crosspost(id string) error {
+	col, err := ObjectBase{Id: id}.GetCollectionLocal()
+
+	if err != nil {
+		return util.MakeError(err, "Crosspost")
+	}
+
+	cached := len(col.OrderedItems) > 0
+
+	if !cached {
+		if !util.IsInstanceAllowed(id) {
+			return errors.New("instance is not federated with")
+		}
+
+		if col, err = (Activity{Id: id}).GetCollection(); err != nil {
+			return util.MakeError(err, "Crosspost")
+		}
+
+		if len(col.OrderedItems) == 0 || col.OrderedItems[0].Actor == "" {
+			return errors.New("no thread found at " + id)
+		}
+	}
+
+	thread := col.OrderedItems[0]
+
+	if len(thread.InReplyTo) > 0 && thread.InReplyTo[0].Id != "" {
+		return errors.New("only threads can be cross-posted")
+	}
+
+	if thread.Actor == actor.Id {
+		return errors.New("thread is already on this board")
+	}
+
+	if !cached {
+		if _, err := thread.WriteCache(); err != nil {
+			return util.MakeError(err, "Crosspost")
+		}
+	}
+
+	crosspost := Crosspost{
+		Board:     actor.Id,
+		Object:    thread.Id,
+		Origin:    thread.Actor,
+		Announcer: actor.Id,
+		Activity:  actor.Id + "#announces/" + util.RandomID(8),
+	}
+
+	if added, err := crosspost.Write(); err != nil || !added {
+		return util.MakeError(err, "Crosspost")
+	}
+
+	announce := GroupAnnounce{
+		Context:   "https://www.w3.org/ns/activitystreams",
+		Id:        crosspost.Activity,
+		Type:      "Announce",
+		Actor:     actor.Id,
+		Object:    thread.Id,
+		To:        []string{publicAddress},
+		Cc:        []string{actor.Id + "/followers"},
+		Published: time.Now().UTC(),
+	}
+
+	return util.MakeError(actor.DeliverToFollowers(announce, ""), "Crosspost")
+}
+
+// RemoveCrosspost takes a cross-posted thread off the board. Followers are
+// sent an Undo when the board announced it itself.
+func (actor Actor) RemoveCrosspost(id string) error {
+	var crosspost Crosspost
+
+	query := `delete from crosspost where board=$1 and object=$2 returning board, object, origin, announcer, activity, created`
+	err := config.DB.QueryRow(query, actor.Id, id).Scan(&crosspost.Board, &crosspost.Object, &crosspost.Origin, &crosspost.Announcer, &crosspost.Activity, &crosspost.Created)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return util.MakeError(err, "RemoveCrosspost")
+	}
+
+	if crosspost.Announcer != actor.Id {
+		return nil
+	}
+
+	undo := struct {
+		Context string      `json:"@context"`
+		Id      string      `json:"id"`
+		Type    string      `json:"type"`
+		Actor   string      `json:"actor"`
+		Object  ActivityRef `json:"object"`
+		To      []string    `json:"to"`
+		Cc      []string    `json:"cc"`
+	}{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      actor.Id + "#undos/" + util.RandomID(8),
+		Type:    "Undo",
+		Actor:   actor.Id,
+		Object: ActivityRef{
+			Id:     crosspost.Activity,
+			Type:   "Announce",
+			Actor:  actor.Id,
+			Object: crosspost.Object,
+		},
+		To: []string{publicAddress},
+		Cc: []string{actor.Id + "/followers"},
+	}
+
+	return util.MakeError(actor.DeliverToFollowers(undo, ""), "RemoveCrosspost")
+}
+
+// Write records the cross-post, it returns false when the thread was
+// already on the board.
+func (crosspost Crosspost) Write() (bool, error) {
+	query := `insert into crosspost (board, object, origin, announcer, activity) values ($1, $2, $3, $4, $5) on conflict do nothing`
+	res, err := config.DB.Exec(query, crosspost.Board, crosspost.Object, crosspost.Origin, crosspost.Announcer, crosspost.Activity)
+
+	if err != nil {
+		return false, util.MakeError(err, "Write")
+	}
+
+	added, err := res.RowsAffected()
+
+	return added > 0, util.MakeError(err, "Write")
+}
+
+func (actor Actor) GetCrossposts() ([]Crosspost, error) {
+	var crossposts []Crosspost
+
+	query := `select board, object, origin, announcer, activity, created from crosspost where board=$1 order by created desc`
+	rows, err := config.DB.Query(query, actor.Id)
+
+	if err != nil {
+		return crossposts, util.MakeError(err, "GetCrossposts")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var crosspost Crosspost
+
+		if err := rows.Scan(&crosspost.Board, &crosspost.Object, &crosspost.Origin, &crosspost.Announcer, &crosspost.Activity, &crosspost.Created); err != nil {
+			return crossposts, util.MakeError(err, "GetCrossposts")
+		}
+
+		crossposts = append(crossposts, crosspost)
+	}
+
+	return crossposts, nil
+}
+
+// GetCrosspostMap returns the board's cross-posts by thread, so pages can
+// look them up for every post they show.
+func (actor Actor) GetCrosspostMap() (map[string]*Crosspost, error) {
+	crossposts, err := actor.GetCrossposts()
+
+	if err != nil {
+		return nil, util.MakeError(err, "GetCrosspostMap")
+	}
+
+	m := make(map[string]*Crosspost, len(crossposts))
+
+	for i, e := range crossposts {
+		m[e.Object] = &crossposts[i]
+	}
+
+	return m, nil
+}
+
+// ProcessCrosspost caches a thread a followed board cross-posted and shows
+// it on the boards following that board.
+func (activity Activity) ProcessCrosspost(boards []Actor) error {
+	thread := activity.Object
+
+	if len(thread.InReplyTo) > 0 && thread.InReplyTo[0].Id != "" {
+		return nil
+	}
+
+	if col, _ := thread.GetCollectionLocal(); len(col.OrderedItems) == 0 {
+		if _, err := thread.WriteCache(); err != nil {
+			return util.MakeError(err, "ProcessCrosspost")
+		}
+	}
+
+	for _, board := range boards {
+		if board.Id == thread.Actor {
+			continue
+		}
+
+		crosspost := Crosspost{
+			Board:     board.Id,
+			Object:    thread.Id,
+			Origin:    thread.Actor,
+			Announcer: activity.Actor.Id,
+			Activity:  activity.Id,
+		}
+
+		if _, err := crosspost.Write(); err != nil {
+			return util.MakeError(err, "ProcessCrosspost")
+		}
+	}
+
+	return nil
+}
+
+// UndoCrosspost takes a thread the sender cross-posted off the boards
+// following it.
+func (activity Activity) UndoCrosspost() error {
+	query := `delete from crosspost where announcer=$1 and ((activity!='' and activity=$2) or object=$3)`
+
+	var object string
+	if activity.Object.Object != nil {
+		object = activity.Object.Object.Id
+	}
+
+	_, err := config.DB.Exec(query, activity.Actor.Id, activity.Object.Id, object)
+
+	return util.MakeError(err, "UndoCrosspost")
+}
@@ -0,0 +1,400 @@
+package activitypub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliveryDead      = "dead"
+)
+
+// A claimed delivery is leased for this long, if the server dies mid request
+// the row becomes due again once the lease runs out.
+const deliveryLease = 10 * time.Minute
+
+const deliveryPollInterval = 5 * time.Second
+const deliveryTimeout = 30 * time.Second
+const deliveryMinBackoff = 30 * time.Second
+const deliveryMaxBackoff = 12 * time.Hour
+
+var deliveryWake = make(chan struct{}, 1)
+
+type Delivery struct {
+	Id          int
+	Actor       string
+	Inbox       string
+	Resolve     bool
+	Payload     string
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+	Updated     time.Time
+}
+
+func EnqueueDelivery(actor string, inbox string, payload []byte) error {
+	query := `insert into deliveryqueue (actor, inbox, payload) values ($1, $2, $3)`
+	if _, err := config.DB.Exec(query, actor, inbox, string(payload)); err != nil {
+		return util.MakeError(err, "EnqueueDelivery")
+	}
+
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// EnqueueUnresolvedDelivery queues a delivery to an actor whose inbox is not
+// known yet. It is stored under the actors own inbox path until a worker
+// resolves it.
+func EnqueueUnresolvedDelivery(actor string, recipient string, payload []byte) error {
+	query := `insert into deliveryqueue (actor, inbox, payload, resolve) values ($1, $2, $3, true)`
+	if _, err := config.DB.Exec(query, actor, recipient+"/inbox", string(payload)); err != nil {
+		return util.MakeError(err, "EnqueueUnresolvedDelivery")
+	}
+
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// resolve looks up the inbox of an unresolved delivery. When the same payload
+// is already queued for the resolved inbox, as happens for several followers
+// behind one shared inbox, the row is dropped and false is returned.
+func (delivery *Delivery) resolve() (bool, error) {
+	inbox := ResolveDeliveryInbox(strings.TrimSuffix(delivery.Inbox, "/inbox"))
+
+	var exists bool
+
+	query := `select exists(select 1 from deliveryqueue where id!=$1 and actor=$2 and inbox=$3 and payload=$4)`
+	if err := config.DB.QueryRow(query, delivery.Id, delivery.Actor, inbox, delivery.Payload).Scan(&exists); err != nil {
+		return false, util.MakeError(err, "resolve")
+	}
+
+	if exists {
+		query = `delete from deliveryqueue where id=$1`
+		_, err := config.DB.Exec(query, delivery.Id)
+		return false, util.MakeError(err, "resolve")
+	}
+
+	query = `update deliveryqueue set inbox=$1, resolve=false, updated=NOW() where id=$2`
+	if _, err := config.DB.Exec(query, inbox, delivery.Id); err != nil {
+		return false, util.MakeError(err, "resolve")
+	}
+
+	delivery.Inbox = inbox
+	delivery.Resolve = false
+
+	return true, nil
+}
+
+func StartDeliveryWorkers() {
+	workers := config.DeliveryWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		go deliveryWorker()
+	}
+
+	for {
+		if err := PruneDeliveries(); err != nil {
+			config.Log.Println(err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+func deliveryWorker() {
+	for {
+		delivery, ok, err := claimDelivery()
+
+		if err != nil {
+			config.Log.Println(err)
+		}
+
+		if !ok {
+			select {
+			case <-deliveryWake:
+			case <-time.After(deliveryPollInterval):
+			}
+			continue
+		}
+
+		if err := delivery.Attempt(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+}
+
+func claimDelivery() (Delivery, bool, error) {
+	var delivery Delivery
+
+	query := `update deliveryqueue set next_attempt=NOW() + make_interval(secs => $1), updated=NOW() where id=(select id from deliveryqueue where status in ('pending', 'failed') and next_attempt <= NOW() order by next_attempt limit 1 for update skip locked) returning id, actor, inbox, resolve, payload, status, attempts, next_attempt, last_error, created, updated`
+	err := config.DB.QueryRow(query, deliveryLease.Seconds()).Scan(&delivery.Id, &delivery.Actor, &delivery.Inbox, &delivery.Resolve, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttempt, &delivery.LastError, &delivery.Created, &delivery.Updated)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, false, nil
+	} else if err != nil {
+		return delivery, false, util.MakeError(err, "claimDelivery")
+	}
+
+	return delivery, true, nil
+}
+
+// Attempt sends the delivery once and records the outcome. Failures are
+// rescheduled with backoff until the attempt limit, client errors other than
+// timeouts and rate limiting go straight to dead.
+func (delivery Delivery) Attempt() error {
+	if IsInstancePaused(delivery.Inbox) {
+		query := `update deliveryqueue set next_attempt=NOW() + make_interval(secs => $1), updated=NOW() where id=$2`
+		_, err := config.DB.Exec(query, pausedRecheck.Seconds(), delivery.Id)
+		return util.MakeError(err, "Attempt")
+	}
+
+	if delivery.Resolve {
+		if ok, err := delivery.resolve(); !ok || err != nil {
+			return util.MakeError(err, "Attempt")
+		}
+	}
+
+	retry, err := false, errors.New("instance is not federated with")
+
+	if util.IsInstanceAllowed(delivery.Inbox) || delivery.IsBlock() {
+		retry, err = delivery.Send()
+
+		if err := RecordDelivery(delivery.Inbox, err); err != nil {
+			config.Log.Println(err)
+		}
+	}
+
+	if err == nil {
+		query := `update deliveryqueue set status=$1, attempts=attempts+1, last_error='', updated=NOW() where id=$2`
+		_, err := config.DB.Exec(query, DeliveryDelivered, delivery.Id)
+		return util.MakeError(err, "Attempt")
+	}
+
+	delivery.Attempts++
+
+	status := DeliveryFailed
+	backoff := deliveryBackoff(delivery.Attempts)
+
+	if !retry || delivery.Attempts >= config.DeliveryMaxAttempts {
+		status = DeliveryDead
+	}
+
+	query := `update deliveryqueue set status=$1, attempts=$2, next_attempt=NOW() + make_interval(secs => $3), last_error=$4, updated=NOW() where id=$5`
+	_, dberr := config.DB.Exec(query, status, delivery.Attempts, backoff.Seconds(), err.Error(), delivery.Id)
+
+	return util.MakeError(dberr, "Attempt")
+}
+
+// IsBlock reports whether the payload is a Block, the one activity still
+// sent to an instance after it was blocked.
+func (delivery Delivery) IsBlock() bool {
+	var activity struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal([]byte(delivery.Payload), &activity); err != nil {
+		return false
+	}
+
+	return activity.Type == "Block"
+}
+
+// Send posts the payload to the recipient inbox signed by the delivering
+// actor. The returned bool reports whether a failure is worth retrying.
+func (delivery Delivery) Send() (bool, error) {
+	actor, err := GetActorFromDB(delivery.Actor)
+
+	if err != nil {
+		return false, fmt.Errorf("no local actor %s to sign with", delivery.Actor)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.Inbox, strings.NewReader(delivery.Payload))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", config.ActivityStreams)
+
+	scheme, _ := GetSignatureScheme(req.URL.Host)
+
+	if err := actor.SignRequest(req, []byte(delivery.Payload)); err != nil {
+		return true, err
+	}
+
+	entry := NewFederationLogEntry(FederationOutbound, delivery.Inbox, []byte(delivery.Payload))
+	entry.Headers = FormatFederationLogRequest(req)
+
+	resp, err := util.RouteProxy(req)
+
+	if err != nil {
+		entry.Error = err.Error()
+		if err := entry.Write(); err != nil {
+			config.Log.Println(err)
+		}
+
+		return true, err
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+
+	entry.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		entry.Error = strings.TrimSpace(string(body[:min(len(body), 1024)]))
+	}
+
+	if err := entry.Write(); err != nil {
+		config.Log.Println(err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	// A peer that signed to us with RFC 9421 may still refuse it on its
+	// inbox, go back to the draft signatures and try again.
+	if scheme == SchemeRFC9421 && (resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		if err := SetSignatureScheme(req.URL.Host, SchemeCavage, ""); err != nil {
+			return true, err
+		}
+
+		return true, fmt.Errorf("%s rejected RFC 9421 signature with %s", delivery.Inbox, resp.Status)
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+
+	return retry, fmt.Errorf("%s returned %s", delivery.Inbox, resp.Status)
+}
+
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := deliveryMaxBackoff
+
+	if attempts < 16 {
+		backoff = deliveryMinBackoff << (attempts - 1)
+	}
+
+	if backoff > deliveryMaxBackoff {
+		backoff = deliveryMaxBackoff
+	}
+
+	// Jitter over the upper half so a burst of failed deliveries to the same
+	// instance does not come back all at once
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+func GetDeliveries(status string, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+
+	query := `select id, actor, inbox, payload, status, attempts, next_attempt, last_error, created, updated from deliveryqueue where status=$1 order by updated desc limit $2`
+	rows, err := config.DB.Query(query, status, limit)
+
+	if err != nil {
+		return deliveries, util.MakeError(err, "GetDeliveries")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var delivery Delivery
+
+		if err := rows.Scan(&delivery.Id, &delivery.Actor, &delivery.Inbox, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttempt, &delivery.LastError, &delivery.Created, &delivery.Updated); err != nil {
+			return deliveries, util.MakeError(err, "GetDeliveries")
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func GetDeliveryCounts() (map[string]int, error) {
+	counts := map[string]int{DeliveryPending: 0, DeliveryDelivered: 0, DeliveryFailed: 0, DeliveryDead: 0}
+
+	query := `select status, count(*) from deliveryqueue group by status`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return counts, util.MakeError(err, "GetDeliveryCounts")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, util.MakeError(err, "GetDeliveryCounts")
+		}
+
+		counts[status] = count
+	}
+
+	return counts, nil
+}
+
+func RetryDelivery(id int) error {
+	query := `update deliveryqueue set status=$1, attempts=0, next_attempt=NOW(), updated=NOW() where id=$2 and status!=$3`
+	_, err := config.DB.Exec(query, DeliveryPending, id, DeliveryDelivered)
+
+	return util.MakeError(err, "RetryDelivery")
+}
+
+func RetryDeliveries(status string) error {
+	query := `update deliveryqueue set status=$1, attempts=0, next_attempt=NOW(), updated=NOW() where status=$2`
+	_, err := config.DB.Exec(query, DeliveryPending, status)
+
+	return util.MakeError(err, "RetryDeliveries")
+}
+
+func DeleteDelivery(id int) error {
+	query := `delete from deliveryqueue where id=$1`
+	_, err := config.DB.Exec(query, id)
+
+	return util.MakeError(err, "DeleteDelivery")
+}
+
+func PurgeDeliveries(status string) error {
+	query := `delete from deliveryqueue where status=$1`
+	_, err := config.DB.Exec(query, status)
+
+	return util.MakeError(err, "PurgeDeliveries")
+}
+
+// PruneDeliveries drops finished deliveries after a week, dead ones are kept
+// until an admin retries or purges them.
+func PruneDeliveries() error {
+	query := `delete from deliveryqueue where status=$1 and updated < NOW() - interval '7 days'`
+	_, err := config.DB.Exec(query, DeliveryDelivered)
+
+	return util.MakeError(err, "PruneDeliveries")
+}
@@ -0,0 +1,145 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+const (
+	FederationInbound  = "in"
+	FederationOutbound = "out"
+)
+
+// Headers kept with a log entry, enough to tell why a signature failed.
+var FederationLogHeaders = []string{"Host", "Date", "Digest", "Content-Digest", "Content-Type", "Signature", "Signature-Input", "User-Agent"}
+
+// Anything shaped like an IPv4 or IPv6 address, confirmed with net.ParseIP
+// before it is redacted.
+var ipCandidate = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b|[0-9A-Fa-f]*:[0-9A-Fa-f]*:[0-9A-Fa-f:.]*`)
+
+type FederationLogEntry struct {
+	Id        int
+	Direction string
+	Instance  string
+	Actor     string
+	Type      string
+	Url       string
+	Status    int
+	Verified  string
+	Headers   string
+	Payload   string
+	Error     string
+	Created   time.Time
+}
+
+// NewFederationLogEntry fills in the actor and type from an activity body.
+func NewFederationLogEntry(direction string, url string, payload []byte) FederationLogEntry {
+	entry := FederationLogEntry{Direction: direction, Url: url, Payload: string(payload)}
+
+	var raw ActivityRaw
+
+	if err := json.Unmarshal(payload, &raw); err == nil {
+		entry.Type = raw.Type
+
+		if actor, err := GetActorFromJson(raw.ActorRaw); err == nil {
+			entry.Actor = actor.Id
+		}
+	}
+
+	if direction == FederationInbound {
+		entry.Instance = util.GetInstanceHost(entry.Actor)
+	} else {
+		entry.Instance = util.GetInstanceHost(url)
+	}
+
+	return entry
+}
+
+// FormatFederationLogHeaders keeps the headers in FederationLogHeaders, get
+// is ctx.Get or http.Header.Get.
+func FormatFederationLogHeaders(get func(string) string) string {
+	var headers []string
+
+	for _, e := range FederationLogHeaders {
+		if v := get(e); v != "" {
+			headers = append(headers, e+": "+v)
+		}
+	}
+
+	return strings.Join(headers, "\n")
+}
+
+func FormatFederationLogRequest(req *http.Request) string {
+	return FormatFederationLogHeaders(func(key string) string {
+		if key == "Host" {
+			return req.Host
+		}
+
+		return req.Header.Get(key)
+	})
+}
+
+// Write stores the entry with any IP addresses redacted and drops the
+// oldest entries past the federationlog limit.
+func (entry FederationLogEntry) Write() error {
+	if config.FederationLogSize < 1 {
+		return nil
+	}
+
+	entry.Headers = RedactIPs(entry.Headers)
+	entry.Payload = RedactIPs(entry.Payload)
+	entry.Error = RedactIPs(entry.Error)
+
+	query := `insert into federationlog (direction, instance, actor, type, url, status, verified, headers, payload, error) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	if _, err := config.DB.Exec(query, entry.Direction, entry.Instance, entry.Actor, entry.Type, entry.Url, entry.Status, entry.Verified, entry.Headers, entry.Payload, entry.Error); err != nil {
+		return util.MakeError(err, "Write")
+	}
+
+	query = `delete from federationlog where id <= (select id from federationlog order by id desc offset $1 limit 1)`
+	_, err := config.DB.Exec(query, config.FederationLogSize)
+
+	return util.MakeError(err, "Write")
+}
+
+func RedactIPs(s string) string {
+	return ipCandidate.ReplaceAllStringFunc(s, func(match string) string {
+		if ip := net.ParseIP(match); ip != nil {
+			return "[redacted]"
+		}
+
+		return match
+	})
+}
+
+// GetFederationLog returns the newest entries matching the filters, empty
+// filters match everything.
+func GetFederationLog(instance string, actor string, activityType string, limit int) ([]FederationLogEntry, error) {
+	var entries []FederationLogEntry
+
+	query := `select id, direction, instance, actor, type, url, status, verified, headers, payload, error, created from federationlog where ($1='' or instance like '%' || $1 || '%') and ($2='' or actor like '%' || $2 || '%') and ($3='' or lower(type)=lower($3)) order by id desc limit $4`
+	rows, err := config.DB.Query(query, instance, actor, activityType, limit)
+
+	if err != nil {
+		return entries, util.MakeError(err, "GetFederationLog")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var e FederationLogEntry
+
+		if err := rows.Scan(&e.Id, &e.Direction, &e.Instance, &e.Actor, &e.Type, &e.Url, &e.Status, &e.Verified, &e.Headers, &e.Payload, &e.Error, &e.Created); err != nil {
+			return entries, util.MakeError(err, "GetFederationLog")
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
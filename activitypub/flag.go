@@ -0,0 +1,132 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// Longest report reason the reported table holds.
+const maxReportReason = 100
+
+// Flag forwards a report on a cached post to the board it came from.
+type Flag struct {
+	Context string   `json:"@context"`
+	Id      string   `json:"id"`
+	Type    string   `json:"type"`
+	Actor   string   `json:"actor"`
+	Object  []string `json:"object"`
+	Content string   `json:"content"`
+	To      []string `json:"to"`
+}
+
+// Flag sends a report on a post cached from another instance to the board it
+// was posted on. Posts that live here have nowhere to be forwarded to.
+func (actor Actor) Flag(id string, reason string) error {
+	col, err := ObjectBase{Id: id}.GetCollectionLocal()
+
+	if err != nil {
+		return util.MakeError(err, "Flag")
+	}
+
+	if len(col.OrderedItems) == 0 {
+		return errors.New("no cached post " + id)
+	}
+
+	origin := col.OrderedItems[0].Actor
+
+	if local, _ := (Actor{Id: origin}).IsLocal(); local || origin == "" {
+		return nil
+	}
+
+	flag := Flag{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      actor.Id + "#flags/" + util.RandomID(8),
+		Type:    "Flag",
+		Actor:   actor.Id,
+		Object:  []string{id},
+		Content: reason,
+		To:      []string{origin},
+	}
+
+	payload, err := json.Marshal(flag)
+
+	if err != nil {
+		return util.MakeError(err, "Flag")
+	}
+
+	return util.MakeError(DeliverToInboxes(actor.Id, flag.To, payload), "Flag")
+}
+
+// GetFlaggedObject picks the post a Flag reports. Microblog software lists
+// the reported account ahead of its posts, so the first of our own posts is
+// taken.
+func GetFlaggedObject(data json.RawMessage) (ObjectBase, error) {
+	list, err := objectList(data)
+
+	if err != nil {
+		return ObjectBase{}, util.MakeError(err, "GetFlaggedObject")
+	}
+
+	for _, e := range list {
+		if local, _ := e.IsLocal(); local {
+			return ObjectBase{Id: e.Id}, nil
+		}
+	}
+
+	if len(list) > 0 {
+		return ObjectBase{Id: list[0].Id}, nil
+	}
+
+	return ObjectBase{}, nil
+}
+
+// ProcessFlag adds a report another instance sent on one of our posts to the
+// report queue of its board, tagged with the reporting instance. Repeats of a
+// report from the same instance are dropped.
+func (activity Activity) ProcessFlag() error {
+	if local, _ := activity.Object.IsLocal(); !local {
+		return nil
+	}
+
+	col, err := activity.Object.GetCollectionLocal()
+
+	if err != nil || len(col.OrderedItems) == 0 {
+		return util.MakeError(err, "ProcessFlag")
+	}
+
+	board, err := GetActorFromDB(col.OrderedItems[0].Actor)
+
+	if err != nil || board.Id == "" {
+		return util.MakeError(err, "ProcessFlag")
+	}
+
+	instance := util.GetInstanceHost(activity.Actor.Id)
+
+	reason := activity.Content
+	if r := []rune(reason); len(r) > maxReportReason {
+		reason = string(r[:maxReportReason])
+	}
+
+	var count int
+
+	query := `select count(*) from reported where id=$1 and instance=$2 and reason=$3`
+	if err := config.DB.QueryRow(query, activity.Object.Id, instance, reason).Scan(&count); err != nil {
+		return util.MakeError(err, "ProcessFlag")
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	query = `insert into reported (id, count, board, reason, instance) values ($1, $2, $3, $4, $5)`
+	if _, err := config.DB.Exec(query, activity.Object.Id, 1, board.PreferredUsername, reason, instance); err != nil {
+		return util.MakeError(err, "ProcessFlag")
+	}
+
+	config.Log.Println(instance + " reported " + activity.Object.Id)
+
+	return nil
+}
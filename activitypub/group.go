@@ -0,0 +1,288 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// ActorContext is published with actor documents. Software that expands
+// JSON-LD ignores an actor without it, the board terms are ours.
+var ActorContext = []interface{}{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+	"https://w3id.org/security/multikey/v1",
+	map[string]interface{}{
+		"fchan":           FChannelNamespace,
+		"authrequirement": "fchan:authrequirement",
+		"restricted":      "fchan:restricted",
+		"boardtype":       "fchan:boardtype",
+		"optionsmask":     "fchan:optionsmask",
+		"alsoKnownAs":     map[string]string{"@id": "as:alsoKnownAs", "@type": "@id"},
+		"movedTo":         map[string]string{"@id": "as:movedTo", "@type": "@id"},
+	},
+}
+
+// FollowAccept answers a Follow from software that names the board as the
+// object. The Follow is echoed back, which is how it finds the request
+// being accepted.
+type FollowAccept struct {
+	Context string      `json:"@context"`
+	Id      string      `json:"id"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	Object  ActivityRef `json:"object"`
+	To      []string    `json:"to"`
+}
+
+// GroupAnnounce is how a board passes on a post a user sent it, the way
+// FEP-1b12 groups share what is posted to them, or a thread cross-posted
+// from another board. Object is an ActivityRef or the id of the thread.
+type GroupAnnounce struct {
+	Context   string      `json:"@context"`
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Object    interface{} `json:"object"`
+	To        []string    `json:"to"`
+	Cc        []string    `json:"cc"`
+	Published time.Time   `json:"published"`
+}
+
+// ActivityRef refers to an activity and its object by id, receivers fetch
+// the object from where it lives rather than trusting the sender's copy.
+type ActivityRef struct {
+	Id     string `json:"id,omitempty"`
+	Type   string `json:"type"`
+	Actor  string `json:"actor"`
+	Object string `json:"object"`
+}
+
+// ProcessGroupFollow adds a follower from software that puts the board in
+// the object of its Follow and accepts it. Unlike the Follows boards send
+// each other, repeating one does not unfollow.
+func (activity Activity) ProcessGroupFollow() error {
+	board, err := GetActorFromDB(activity.Object.Id)
+
+	if err != nil || board.Id == "" || board.Id == activity.Actor.Id {
+		return nil
+	}
+
+	follower, err := board.IsAlreadyFollower(activity.Actor.Id)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessGroupFollow")
+	}
+
+	if !follower {
+		query := `insert into follower (id, follower) values ($1, $2)`
+		if _, err := config.DB.Exec(query, board.Id, activity.Actor.Id); err != nil {
+			return util.MakeError(err, "ProcessGroupFollow")
+		}
+
+		config.Log.Println(activity.Actor.Id + " followed " + board.Id)
+	}
+
+	accept := FollowAccept{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      board.Id + "#accepts/follows/" + util.RandomID(8),
+		Type:    "Accept",
+		Actor:   board.Id,
+		Object: ActivityRef{
+			Id:     activity.Id,
+			Type:   "Follow",
+			Actor:  activity.Actor.Id,
+			Object: board.Id,
+		},
+		To: []string{activity.Actor.Id},
+	}
+
+	payload, err := json.Marshal(accept)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessGroupFollow")
+	}
+
+	return util.MakeError(DeliverToInboxes(board.Id, accept.To, payload), "ProcessGroupFollow")
+}
+
+// Announce passes a Create the board took from a user on to its followers,
+// except the user who sent it.
+func (actor Actor) Announce(activity Activity) error {
+	announce := GroupAnnounce{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      actor.Id + "#announces/" + util.RandomID(8),
+		Type:    "Announce",
+		Actor:   actor.Id,
+		Object: ActivityRef{
+			Id:     activity.Id,
+			Type:   "Create",
+			Actor:  activity.Actor.Id,
+			Object: activity.Object.Id,
+		},
+		To:        []string{publicAddress},
+		Cc:        []string{actor.Id + "/followers"},
+		Published: time.Now().UTC(),
+	}
+
+	return util.MakeError(actor.DeliverToFollowers(announce, activity.Actor.Id), "Announce")
+}
+
+// DeliverToFollowers queues an activity for every follower of the board but
+// except.
+func (actor Actor) DeliverToFollowers(activity interface{}, except string) error {
+	followers, err := actor.GetFollower()
+
+	if err != nil {
+		return util.MakeError(err, "DeliverToFollowers")
+	}
+
+	var to []string
+
+	for _, e := range followers {
+		if e.Id != except {
+			to = append(to, e.Id)
+		}
+	}
+
+	if len(to) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(activity)
+
+	if err != nil {
+		return util.MakeError(err, "DeliverToFollowers")
+	}
+
+	return util.MakeError(DeliverToInboxes(actor.Id, to, payload), "DeliverToFollowers")
+}
+
+// ProcessAnnounce takes a post a remote group announced onto the boards
+// following it, or a thread a board cross-posted. The post is fetched from
+// where it lives, the announcer only vouches for it being posted there.
+// Boosts of anything but posts are ignored.
+func (activity Activity) ProcessAnnounce() error {
+	id := activity.Object.Id
+
+	switch {
+	case activity.Object.Type == "Create":
+		if activity.Object.Object == nil {
+			return nil
+		}
+
+		id = activity.Object.Object.Id
+
+	case activity.Object.Type != "" && !IsPostType(activity.Object.Type):
+		return nil
+	}
+
+	if id == "" || !util.IsInstanceAllowed(id) {
+		return nil
+	}
+
+	if following, err := activity.Actor.IsFollowed(); err != nil || !following {
+		return util.MakeError(err, "ProcessAnnounce")
+	}
+
+	col, err := Activity{Id: id}.GetCollection()
+
+	if err != nil {
+		return util.MakeError(err, "ProcessAnnounce")
+	}
+
+	if len(col.OrderedItems) == 0 || !IsPostType(col.OrderedItems[0].Type) {
+		return nil
+	}
+
+	activity.Object = col.OrderedItems[0]
+
+	accepted, ok, err := activity.ApplyPolicies(false)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessAnnounce")
+	}
+
+	if ok {
+		return util.MakeError(accepted.ProcessAnnounced(), "ProcessAnnounce")
+	}
+
+	return nil
+}
+
+// ProcessAnnounced caches an announced post that passed the federation
+// policies for the boards following the announcer. Posts naming the board
+// they are on are threads cross-posted by a board, anything else was posted
+// to a group.
+func (activity Activity) ProcessAnnounced() error {
+	boards, err := GetLocalFollowers(activity.Actor.Id)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessAnnounced")
+	}
+
+	if activity.Object.Actor != "" {
+		return util.MakeError(activity.ProcessCrosspost(boards), "ProcessAnnounced")
+	}
+
+	author, err := GetActor(activity.Object.AttributedTo)
+
+	if err != nil || author.Id == "" {
+		author = Actor{Id: activity.Object.AttributedTo}
+	}
+
+	for _, board := range boards {
+		if _, err := board.CachePost(author, activity.Object, activity.Actor.Id); err != nil {
+			return util.MakeError(err, "ProcessAnnounced")
+		}
+	}
+
+	return nil
+}
+
+// GetLocalFollowers returns the local boards following an actor.
+func GetLocalFollowers(id string) ([]Actor, error) {
+	var boards []Actor
+
+	query := `select id from following where following=$1`
+	rows, err := config.DB.Query(query, id)
+
+	if err != nil {
+		return boards, util.MakeError(err, "GetLocalFollowers")
+	}
+
+	var ids []string
+
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return boards, util.MakeError(err, "GetLocalFollowers")
+		}
+
+		ids = append(ids, id)
+	}
+
+	for _, e := range ids {
+		if local, _ := (Actor{Id: e}).IsLocal(); local {
+			boards = append(boards, Actor{Id: e})
+		}
+	}
+
+	return boards, nil
+}
+
+// IsFollowed reports whether a local board follows the actor.
+func (actor Actor) IsFollowed() (bool, error) {
+	var count int
+
+	query := `select count(*) from following where following=$1`
+	if err := config.DB.QueryRow(query, actor.Id).Scan(&count); err != nil {
+		return false, util.MakeError(err, "IsFollowed")
+	}
+
+	return count > 0, nil
+}
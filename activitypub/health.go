@@ -0,0 +1,370 @@
+package activitypub
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// Deliveries to a paused instance are looked at again after this long.
+const pausedRecheck = time.Hour
+
+type InstanceHealth struct {
+	Instance     string
+	LastInbound  time.Time
+	LastOutbound time.Time
+	Delivered    int
+	Failed       int
+	LastError    string
+	LastErrorAt  time.Time
+	Software     string
+	Version      string
+	Paused       bool
+	Created      time.Time
+	Followers    int
+	Following    int
+}
+
+// SuccessRate is the percentage of delivery attempts that succeeded.
+func (health InstanceHealth) SuccessRate() int {
+	if health.Delivered+health.Failed == 0 {
+		return 0
+	}
+
+	return health.Delivered * 100 / (health.Delivered + health.Failed)
+}
+
+func RecordInbound(id string) error {
+	instance := util.GetInstanceHost(id)
+
+	if instance == "" || instance == util.GetInstanceHost(config.Domain) {
+		return nil
+	}
+
+	query := `insert into instancehealth (instance, last_inbound) values ($1, NOW()) on conflict (instance) do update set last_inbound=NOW()`
+	_, err := config.DB.Exec(query, instance)
+
+	return util.MakeError(err, "RecordInbound")
+}
+
+func RecordDelivery(inbox string, deliveryErr error) error {
+	instance := util.GetInstanceHost(inbox)
+
+	if instance == "" || instance == util.GetInstanceHost(config.Domain) {
+		return nil
+	}
+
+	if deliveryErr == nil {
+		query := `insert into instancehealth (instance, last_outbound, delivered) values ($1, NOW(), 1) on conflict (instance) do update set last_outbound=NOW(), delivered=instancehealth.delivered+1`
+		_, err := config.DB.Exec(query, instance)
+		return util.MakeError(err, "RecordDelivery")
+	}
+
+	query := `insert into instancehealth (instance, failed, last_error, last_error_at) values ($1, 1, $2, NOW()) on conflict (instance) do update set failed=instancehealth.failed+1, last_error=$2, last_error_at=NOW()`
+	_, err := config.DB.Exec(query, instance, deliveryErr.Error())
+
+	return util.MakeError(err, "RecordDelivery")
+}
+
+func IsInstancePaused(id string) bool {
+	var paused bool
+
+	query := `select paused from instancehealth where instance=$1`
+	config.DB.QueryRow(query, util.GetInstanceHost(id)).Scan(&paused)
+
+	return paused
+}
+
+func SetInstancePaused(instance string, paused bool) error {
+	query := `insert into instancehealth (instance, paused) values ($1, $2) on conflict (instance) do update set paused=$2`
+	_, err := config.DB.Exec(query, util.GetInstanceHost(instance), paused)
+
+	return util.MakeError(err, "SetInstancePaused")
+}
+
+// GetInstanceHealth lists every instance we have traffic or follows with,
+// along with how many follows there are in each direction.
+func GetInstanceHealth() ([]InstanceHealth, error) {
+	var list []InstanceHealth
+
+	instances, err := getRemoteInstances()
+
+	if err != nil {
+		return list, util.MakeError(err, "GetInstanceHealth")
+	}
+
+	health := make(map[string]*InstanceHealth)
+
+	for host := range instances {
+		health[host] = &InstanceHealth{Instance: host}
+	}
+
+	query := `select instance, last_inbound, last_outbound, delivered, failed, last_error, last_error_at, software, version, paused, created from instancehealth`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return list, util.MakeError(err, "GetInstanceHealth")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var e InstanceHealth
+		var inbound, outbound, errorAt sql.NullTime
+
+		if err := rows.Scan(&e.Instance, &inbound, &outbound, &e.Delivered, &e.Failed, &e.LastError, &errorAt, &e.Software, &e.Version, &e.Paused, &e.Created); err != nil {
+			return list, util.MakeError(err, "GetInstanceHealth")
+		}
+
+		e.LastInbound, e.LastOutbound, e.LastErrorAt = inbound.Time, outbound.Time, errorAt.Time
+		health[e.Instance] = &e
+	}
+
+	if err := countInstanceFollows(`select follower from follower`, health, func(e *InstanceHealth) { e.Followers++ }); err != nil {
+		return list, util.MakeError(err, "GetInstanceHealth")
+	}
+
+	if err := countInstanceFollows(`select following from following`, health, func(e *InstanceHealth) { e.Following++ }); err != nil {
+		return list, util.MakeError(err, "GetInstanceHealth")
+	}
+
+	for _, e := range health {
+		list = append(list, *e)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Instance < list[j].Instance })
+
+	return list, nil
+}
+
+func countInstanceFollows(query string, health map[string]*InstanceHealth, count func(*InstanceHealth)) error {
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return util.MakeError(err, "countInstanceFollows")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return util.MakeError(err, "countInstanceFollows")
+		}
+
+		if e, ok := health[util.GetInstanceHost(id)]; ok {
+			count(e)
+		}
+	}
+
+	return nil
+}
+
+// getRemoteInstances maps the host of every remote follower and followed
+// board to the base url it was seen with.
+func getRemoteInstances() (map[string]string, error) {
+	instances := make(map[string]string)
+
+	query := `select follower from follower union select following from following`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return instances, util.MakeError(err, "getRemoteInstances")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return instances, util.MakeError(err, "getRemoteInstances")
+		}
+
+		u, err := url.Parse(id)
+
+		if err != nil || u.Host == "" {
+			continue
+		}
+
+		host := util.GetInstanceHost(id)
+
+		if host != util.GetInstanceHost(config.Domain) {
+			instances[host] = u.Scheme + "://" + u.Host
+		}
+	}
+
+	return instances, nil
+}
+
+func StartInstanceHealth() {
+	for {
+		if err := CheckInstanceHealth(); err != nil {
+			config.Log.Println(err)
+		}
+
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// CheckInstanceHealth refreshes the software of every instance we federate
+// with and drops the followers of instances that have stopped accepting our
+// deliveries.
+func CheckInstanceHealth() error {
+	instances, err := getRemoteInstances()
+
+	if err != nil {
+		return util.MakeError(err, "CheckInstanceHealth")
+	}
+
+	for host, base := range instances {
+		if !util.IsInstanceAllowed(host) {
+			continue
+		}
+
+		if software, version, err := GetNodeInfoSoftware(base); err == nil {
+			query := `insert into instancehealth (instance, software, version) values ($1, $2, $3) on conflict (instance) do update set software=$2, version=$3`
+			if _, err := config.DB.Exec(query, host, software, version); err != nil {
+				return util.MakeError(err, "CheckInstanceHealth")
+			}
+		}
+	}
+
+	if config.DeadFollowerDays < 1 {
+		return nil
+	}
+
+	// Dead means every delivery for the whole period failed, an instance we
+	// have never managed to deliver to counts from when it was first seen.
+	query := `select instance from instancehealth where last_error_at is not null and coalesce(last_outbound, created) < NOW() - make_interval(days => $1) and last_error_at > coalesce(last_outbound, created)`
+	rows, err := config.DB.Query(query, config.DeadFollowerDays)
+
+	if err != nil {
+		return util.MakeError(err, "CheckInstanceHealth")
+	}
+
+	var dead []string
+
+	defer rows.Close()
+	for rows.Next() {
+		var instance string
+		rows.Scan(&instance)
+		dead = append(dead, instance)
+	}
+
+	for _, instance := range dead {
+		if err := DropInstanceFollowers(instance); err != nil {
+			return util.MakeError(err, "CheckInstanceHealth")
+		}
+	}
+
+	return nil
+}
+
+func DropInstanceFollowers(instance string) error {
+	query := `select id, follower from follower`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return util.MakeError(err, "DropInstanceFollowers")
+	}
+
+	type follow struct {
+		local  string
+		remote string
+	}
+
+	var follows []follow
+
+	defer rows.Close()
+	for rows.Next() {
+		var e follow
+		rows.Scan(&e.local, &e.remote)
+
+		if util.GetInstanceHost(e.remote) == instance {
+			follows = append(follows, e)
+		}
+	}
+
+	for _, e := range follows {
+		config.Log.Println("Dropping dead follower " + e.remote + " of " + e.local)
+
+		if err := (Actor{Id: e.local}).RemoveFollower(e.remote); err != nil {
+			return util.MakeError(err, "DropInstanceFollowers")
+		}
+	}
+
+	return nil
+}
+
+// GetNodeInfoSoftware reads the software name and version an instance
+// publishes through NodeInfo.
+func GetNodeInfoSoftware(base string) (string, string, error) {
+	var discover struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+
+	if err := getNodeInfoJson(base+"/.well-known/nodeinfo", &discover); err != nil {
+		return "", "", util.MakeError(err, "GetNodeInfoSoftware")
+	}
+
+	var href string
+
+	for _, e := range discover.Links {
+		if strings.HasPrefix(e.Rel, "http://nodeinfo.diaspora.software/ns/schema/") {
+			href = e.Href
+		}
+	}
+
+	if href == "" {
+		return "", "", util.MakeError(errors.New("no nodeinfo link for "+base), "GetNodeInfoSoftware")
+	}
+
+	var info struct {
+		Software struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"software"`
+	}
+
+	if err := getNodeInfoJson(href, &info); err != nil {
+		return "", "", util.MakeError(err, "GetNodeInfoSoftware")
+	}
+
+	return info.Software.Name, info.Software.Version, nil
+}
+
+func getNodeInfoJson(address string, v interface{}) error {
+	req, err := http.NewRequest("GET", address, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := util.RouteProxy(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", address, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,375 @@
+package activitypub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// ProcessInbox handles an activity that has already had its signature
+// verified, for every local actor it is addressed to.
+func (activity Activity) ProcessInbox() error {
+	if !util.IsInstanceAllowed(activity.Object.Id) {
+		return nil
+	}
+
+	switch activity.Type {
+	case "Create":
+		accepted, ok, err := activity.ApplyPolicies(false)
+
+		if err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+		if ok {
+			return util.MakeError(accepted.ProcessCreate(), "ProcessInbox")
+		}
+
+	case "Delete":
+		// A user account can only remove its own replies
+		if !activity.Actor.IsBoard() {
+			owner, err := activity.Object.GetCacheOwner()
+
+			if err != nil {
+				return util.MakeError(err, "ProcessInbox")
+			} else if owner == "" {
+				return nil
+			} else if owner != activity.Actor.Id {
+				return util.MakeError(errors.New(activity.Actor.Id+" can not delete "+activity.Object.Id), "ProcessInbox")
+			}
+
+			return util.MakeError(activity.Object.Tombstone(), "ProcessInbox")
+		}
+
+		for _, e := range activity.To {
+			actor, err := GetActorFromDB(e)
+			if err != nil {
+				continue // try again
+				// return util.MakeError(err, "ProcessInbox")
+			}
+
+			if actor.Id != "" && actor.Id != config.Domain {
+				if activity.Object.Replies != nil {
+					for _, k := range activity.Object.Replies.OrderedItems {
+						if err := k.Tombstone(); err != nil {
+							return util.MakeError(err, "ProcessInbox")
+						}
+					}
+				}
+
+				if err := activity.Object.Tombstone(); err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				if err := actor.UnArchiveLast(); err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+				break
+			}
+		}
+
+	case "Follow":
+		// Boards name who they follow as the object's actor, other
+		// software names it as the object
+		if activity.Object.Actor == "" {
+			return util.MakeError(activity.ProcessGroupFollow(), "ProcessInbox")
+		}
+
+		for _, e := range activity.To {
+			if _, err := GetActorFromDB(e); err == nil {
+				response := activity.AcceptFollow()
+				response, err := response.SetActorFollower()
+
+				if err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				if err := response.MakeRequestInbox(); err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				alreadyFollowing, err := response.Actor.IsAlreadyFollowing(response.Object.Id)
+
+				if err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				objActor, err := FingerActor(response.Object.Actor)
+
+				if err != nil || objActor.Id == "" {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				reqActivity := Activity{Id: objActor.Following}
+				remoteActorFollowingCol, err := reqActivity.GetCollection()
+
+				if err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				alreadyFollow := false
+
+				for _, e := range remoteActorFollowingCol.Items {
+					if e.Id == response.Actor.Id {
+						alreadyFollowing = true
+					}
+				}
+
+				autoSub, err := response.Actor.GetAutoSubscribe()
+
+				if err != nil {
+					return util.MakeError(err, "ProcessInbox")
+				}
+
+				if autoSub && !alreadyFollow && alreadyFollowing {
+					followActivity, err := response.Actor.MakeFollowActivity(response.Object.Actor)
+
+					if err != nil {
+						return util.MakeError(err, "ProcessInbox")
+					}
+
+					if err := followActivity.MakeRequestOutbox(); err != nil {
+						return util.MakeError(err, "ProcessInbox")
+					}
+				}
+			} else if err != nil {
+				return util.MakeError(err, "ProcessInbox")
+			} else {
+				config.Log.Println("follow request for rejected")
+				response := activity.Reject()
+				return response.MakeRequestInbox()
+			}
+		}
+
+	case "Announce":
+		if relay, err := activity.Actor.IsRelay(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		} else if relay {
+			return util.MakeError(activity.ProcessRelayAnnounce(), "ProcessInbox")
+		}
+
+		if err := activity.ProcessAnnounce(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Accept":
+		if _, err := activity.ProcessRelayResponse(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Reject":
+		if relay, err := activity.ProcessRelayResponse(); err != nil || relay {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+		if activity.Object.Object != nil && activity.Object.Object.Type == "Follow" {
+			config.Log.Println("follow rejected")
+			if _, err := activity.SetActorFollowing(); err != nil {
+				return util.MakeError(err, "ProcessInbox")
+			}
+		}
+
+	case "Update":
+		if err := activity.ProcessUpdate(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Undo":
+		if err := activity.ProcessUndo(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Block":
+		if err := activity.ProcessBlock(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Flag":
+		if err := activity.ProcessFlag(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+
+	case "Move":
+		if err := activity.ProcessMove(); err != nil {
+			return util.MakeError(err, "ProcessInbox")
+		}
+	}
+
+	return nil
+}
+
+// ProcessCreate hands a Create that passed the federation policies to each
+// board it is addressed to.
+func (activity Activity) ProcessCreate() error {
+	for _, e := range activity.To {
+		actor := Actor{Id: e}
+		if err := actor.ProcessInboxCreate(activity); err != nil {
+			return util.MakeError(err, "ProcessCreate")
+		}
+
+		// Only a board's own posts are passed on, nothing else can be signed
+		// for on a user's behalf
+		if !activity.Actor.IsBoard() {
+			continue
+		}
+
+		if err := actor.SendToFollowers(activity); err != nil {
+			return util.MakeError(err, "ProcessCreate")
+		}
+	}
+
+	for _, e := range activity.Cc {
+		actor := Actor{Id: e}
+		if err := actor.ProcessInboxCreate(activity); err != nil {
+			return util.MakeError(err, "ProcessCreate")
+		}
+	}
+
+	return nil
+}
+
+// ProcessUpdate applies a remote edit to a cached post, as long as the post
+// was cached from the actor sending the edit. An actor updating itself gets
+// refetched, which also picks up a rotated key.
+func (activity Activity) ProcessUpdate() error {
+	if activity.Object.Id != "" && activity.Object.Id == activity.Actor.Id {
+		if local, _ := activity.Actor.IsLocal(); local {
+			return nil
+		}
+
+		_, err := RefreshActor(activity.Actor.Id)
+		return util.MakeError(err, "ProcessUpdate")
+	}
+
+	if activity.Object.Type != "Note" || activity.Object.Id == "" {
+		return nil
+	}
+
+	owner, err := activity.Object.GetCacheOwner()
+
+	if err != nil {
+		return util.MakeError(err, "ProcessUpdate")
+	} else if owner == "" {
+		return nil
+	}
+
+	if owner != activity.Actor.Id {
+		return util.MakeError(errors.New(activity.Actor.Id+" can not update "+activity.Object.Id), "ProcessUpdate")
+	}
+
+	if isBlacklisted, _, regex := util.IsPostBlacklist(activity.Object.Content); isBlacklisted {
+		config.Log.Println("Blacklist post update blocked \nRegex: " + regex + "\n" + activity.Object.Content)
+		return nil
+	}
+
+	err = activity.Object.WriteEdit(time.Now().UTC())
+
+	return util.MakeError(err, "ProcessUpdate")
+}
+
+// ProcessUndo reverts an earlier activity from the sender. Only Follow and
+// the Announce of a cross-post are undone, other types have no lasting state
+// here.
+func (activity Activity) ProcessUndo() error {
+	if activity.Object.Actor != "" && activity.Object.Actor != activity.Actor.Id {
+		return util.MakeError(errors.New("undo of an activity by another actor"), "ProcessUndo")
+	}
+
+	switch activity.Object.Type {
+	case "Follow":
+		if activity.Object.Object == nil {
+			return nil
+		}
+
+		target := activity.Object.Object.Id
+		if target == "" {
+			target = activity.Object.Object.Actor
+		}
+
+		actor := Actor{Id: target}
+		if local, _ := actor.IsLocal(); !local {
+			return nil
+		}
+
+		config.Log.Println(activity.Actor.Id + " unfollowed " + target)
+
+		if err := actor.RemoveFollower(activity.Actor.Id); err != nil {
+			return util.MakeError(err, "ProcessUndo")
+		}
+
+	case "Announce":
+		if err := activity.UndoCrosspost(); err != nil {
+			return util.MakeError(err, "ProcessUndo")
+		}
+	}
+
+	return nil
+}
+
+// AddLocalRecipients addresses an activity that arrived on the shared inbox
+// to the local boards following its sender. Peers batching per instance
+// often only address the followers collection, which we can't resolve.
+func (activity Activity) AddLocalRecipients() (Activity, error) {
+	// Only content is fanned out, a Follow or Reject still has to name the
+	// board it is meant for.
+	if activity.Type != "Create" && activity.Type != "Delete" {
+		return activity, nil
+	}
+
+	query := `select id from following where following=$1`
+	rows, err := config.DB.Query(query, activity.Actor.Id)
+
+	if err != nil {
+		return activity, util.MakeError(err, "AddLocalRecipients")
+	}
+
+	addressed := make(map[string]bool)
+	for _, e := range activity.To {
+		addressed[e] = true
+	}
+	for _, e := range activity.Cc {
+		addressed[e] = true
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return activity, util.MakeError(err, "AddLocalRecipients")
+		}
+
+		if !addressed[id] {
+			activity.To = append(activity.To, id)
+			addressed[id] = true
+		}
+	}
+
+	// A user replying to a thread addresses the poster, make sure the board
+	// the thread is on sees it.
+	if activity.Type == "Create" && !activity.Actor.IsBoard() && len(activity.Object.InReplyTo) > 0 {
+		op, err := activity.Object.InReplyTo[0].GetOP()
+
+		if err != nil {
+			return activity, util.MakeError(err, "AddLocalRecipients")
+		}
+
+		thread, err := ObjectBase{Id: op}.GetCollectionLocal()
+
+		if err != nil {
+			return activity, util.MakeError(err, "AddLocalRecipients")
+		}
+
+		if len(thread.OrderedItems) > 0 {
+			board := Actor{Id: thread.OrderedItems[0].Actor}
+
+			if local, _ := board.IsLocal(); local && !addressed[board.Id] {
+				activity.To = append(activity.To, board.Id)
+			}
+		}
+	}
+
+	return activity, nil
+}
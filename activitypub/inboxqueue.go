@@ -0,0 +1,245 @@
+package activitypub
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+const (
+	InboxPending   = "pending"
+	InboxProcessed = "processed"
+	InboxFailed    = "failed"
+	InboxDead      = "dead"
+)
+
+const inboxLease = 10 * time.Minute
+const inboxPollInterval = 5 * time.Second
+
+var inboxWake = make(chan struct{}, 1)
+
+type InboxItem struct {
+	Id          int
+	Actor       string
+	Type        string
+	Payload     string
+	Status      string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+	Updated     time.Time
+}
+
+// EnqueueInbox stores a verified activity, with its actor resolved and
+// recipients added, for the inbox workers.
+func EnqueueInbox(activity Activity) error {
+	payload, err := json.Marshal(activity)
+
+	if err != nil {
+		return util.MakeError(err, "EnqueueInbox")
+	}
+
+	query := `insert into inboxqueue (actor, type, payload) values ($1, $2, $3)`
+	if _, err := config.DB.Exec(query, activity.Actor.Id, activity.Type, string(payload)); err != nil {
+		return util.MakeError(err, "EnqueueInbox")
+	}
+
+	select {
+	case inboxWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func StartInboxWorkers() {
+	workers := config.InboxWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		go inboxWorker()
+	}
+
+	for {
+		if err := PruneInbox(); err != nil {
+			config.Log.Println(err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+func inboxWorker() {
+	for {
+		item, ok, err := claimInboxItem()
+
+		if err != nil {
+			config.Log.Println(err)
+		}
+
+		if !ok {
+			select {
+			case <-inboxWake:
+			case <-time.After(inboxPollInterval):
+			}
+			continue
+		}
+
+		if err := item.Process(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+}
+
+func claimInboxItem() (InboxItem, bool, error) {
+	var item InboxItem
+
+	query := `update inboxqueue set next_attempt=NOW() + make_interval(secs => $1), updated=NOW() where id=(select id from inboxqueue where status in ('pending', 'failed') and next_attempt <= NOW() order by next_attempt limit 1 for update skip locked) returning id, actor, type, payload, status, attempts, next_attempt, last_error, created, updated`
+	err := config.DB.QueryRow(query, inboxLease.Seconds()).Scan(&item.Id, &item.Actor, &item.Type, &item.Payload, &item.Status, &item.Attempts, &item.NextAttempt, &item.LastError, &item.Created, &item.Updated)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, false, nil
+	} else if err != nil {
+		return item, false, util.MakeError(err, "claimInboxItem")
+	}
+
+	return item, true, nil
+}
+
+// Process runs the queued activity through ProcessInbox and records the
+// outcome. Failures are retried with the same backoff as deliveries, once
+// an activity is dead it is forgotten as seen so the sender can try again.
+func (item InboxItem) Process() error {
+	var activity Activity
+
+	err := json.Unmarshal([]byte(item.Payload), &activity)
+
+	if err == nil {
+		err = activity.ProcessInbox()
+	}
+
+	if err == nil {
+		query := `update inboxqueue set status=$1, attempts=attempts+1, last_error='', updated=NOW() where id=$2`
+		_, err := config.DB.Exec(query, InboxProcessed, item.Id)
+		return util.MakeError(err, "Process")
+	}
+
+	item.Attempts++
+
+	entry := NewFederationLogEntry(FederationInbound, "", []byte(item.Payload))
+	entry.Verified = "valid"
+	entry.Error = fmt.Sprintf("processing attempt %d: %s", item.Attempts, err)
+
+	if err := entry.Write(); err != nil {
+		config.Log.Println(err)
+	}
+
+	status := InboxFailed
+
+	if item.Attempts >= config.InboxMaxAttempts {
+		status = InboxDead
+
+		if err := activity.UnmarkSeen(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+
+	query := `update inboxqueue set status=$1, attempts=$2, next_attempt=NOW() + make_interval(secs => $3), last_error=$4, updated=NOW() where id=$5`
+	_, dberr := config.DB.Exec(query, status, item.Attempts, deliveryBackoff(item.Attempts).Seconds(), err.Error(), item.Id)
+
+	return util.MakeError(dberr, "Process")
+}
+
+func GetInboxItems(status string, limit int) ([]InboxItem, error) {
+	var items []InboxItem
+
+	query := `select id, actor, type, payload, status, attempts, next_attempt, last_error, created, updated from inboxqueue where status=$1 order by updated desc limit $2`
+	rows, err := config.DB.Query(query, status, limit)
+
+	if err != nil {
+		return items, util.MakeError(err, "GetInboxItems")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var item InboxItem
+
+		if err := rows.Scan(&item.Id, &item.Actor, &item.Type, &item.Payload, &item.Status, &item.Attempts, &item.NextAttempt, &item.LastError, &item.Created, &item.Updated); err != nil {
+			return items, util.MakeError(err, "GetInboxItems")
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func GetInboxCounts() (map[string]int, error) {
+	counts := map[string]int{InboxPending: 0, InboxProcessed: 0, InboxFailed: 0, InboxDead: 0}
+
+	query := `select status, count(*) from inboxqueue group by status`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return counts, util.MakeError(err, "GetInboxCounts")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, util.MakeError(err, "GetInboxCounts")
+		}
+
+		counts[status] = count
+	}
+
+	return counts, nil
+}
+
+func RetryInboxItem(id int) error {
+	query := `update inboxqueue set status=$1, attempts=0, next_attempt=NOW(), updated=NOW() where id=$2 and status!=$3`
+	_, err := config.DB.Exec(query, InboxPending, id, InboxProcessed)
+
+	return util.MakeError(err, "RetryInboxItem")
+}
+
+func RetryInboxItems(status string) error {
+	query := `update inboxqueue set status=$1, attempts=0, next_attempt=NOW(), updated=NOW() where status=$2`
+	_, err := config.DB.Exec(query, InboxPending, status)
+
+	return util.MakeError(err, "RetryInboxItems")
+}
+
+func DeleteInboxItem(id int) error {
+	query := `delete from inboxqueue where id=$1`
+	_, err := config.DB.Exec(query, id)
+
+	return util.MakeError(err, "DeleteInboxItem")
+}
+
+func PurgeInboxItems(status string) error {
+	query := `delete from inboxqueue where status=$1`
+	_, err := config.DB.Exec(query, status)
+
+	return util.MakeError(err, "PurgeInboxItems")
+}
+
+// PruneInbox drops processed activities after a day, they are already in
+// the database in their processed form.
+func PruneInbox() error {
+	query := `delete from inboxqueue where status=$1 and updated < NOW() - interval '1 day'`
+	_, err := config.DB.Exec(query, InboxProcessed)
+
+	return util.MakeError(err, "PruneInbox")
+}
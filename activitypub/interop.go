@@ -0,0 +1,223 @@
+package activitypub
+
+import (
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// FChannelNamespace is the JSON-LD namespace of the terms our posts carry on
+// top of ActivityStreams.
+const FChannelNamespace = "https://github.com/anomalous69/FChannel/ns#"
+
+const publicAddress = "https://www.w3.org/ns/activitystreams#Public"
+
+// InteropContext defines our extension terms so software that expands
+// JSON-LD does not drop them.
+var InteropContext = []interface{}{
+	"https://www.w3.org/ns/activitystreams",
+	map[string]string{
+		"fchan":     FChannelNamespace,
+		"sensitive": "as:sensitive",
+		"tripcode":  "fchan:tripcode",
+		"poster":    "fchan:poster",
+		"alias":     "fchan:alias",
+		"sticky":    "fchan:sticky",
+		"locked":    "fchan:locked",
+		"totalImgs": "fchan:totalImgs",
+	},
+}
+
+var interopQuoteLink = regexp.MustCompile(`&gt;&gt;(https?://[^\s<&]+)`)
+
+// InteropNote is a post as plain ActivityStreams. Other FChannel instances
+// read the same document, what boards need beyond ActivityStreams is carried
+// in extension terms.
+type InteropNote struct {
+	Context      interface{}       `json:"@context,omitempty"`
+	Id           string            `json:"id"`
+	Type         string            `json:"type"`
+	AttributedTo string            `json:"attributedTo,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	Content      string            `json:"content,omitempty"`
+	Source       *InteropSource    `json:"source,omitempty"`
+	InReplyTo    string            `json:"inReplyTo,omitempty"`
+	ThreadId     string            `json:"context,omitempty"`
+	Published    *time.Time        `json:"published,omitempty"`
+	Updated      *time.Time        `json:"updated,omitempty"`
+	To           []string          `json:"to,omitempty"`
+	Cc           []string          `json:"cc,omitempty"`
+	Sensitive    bool              `json:"sensitive"`
+	Attachment   []InteropDocument `json:"attachment,omitempty"`
+	Poster       *string           `json:"poster,omitempty"`
+	TripCode     string            `json:"tripcode,omitempty"`
+	Alias        string            `json:"alias,omitempty"`
+	Sticky       bool              `json:"sticky,omitempty"`
+	Locked       bool              `json:"locked,omitempty"`
+	Replies      *InteropReplies   `json:"replies,omitempty"`
+}
+
+type InteropReplies struct {
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	TotalImgs    int           `json:"totalImgs,omitempty"`
+	OrderedItems []InteropNote `json:"orderedItems,omitempty"`
+}
+
+type InteropSource struct {
+	Content   string `json:"content"`
+	MediaType string `json:"mediaType"`
+}
+
+type InteropDocument struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	Url       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+}
+
+// Interop returns the post as an InteropNote. The board is the author, the
+// poster's name and tripcode go in extension terms, and a reply points at
+// the post it quotes or else the thread. A thread carries its replies.
+func (obj ObjectBase) Interop() (InteropNote, error) {
+	note := InteropNote{Context: InteropContext, Id: obj.Id, Type: "Note"}
+
+	if obj.Type != "Note" && obj.Type != "Archive" {
+		note.Type = "Tombstone"
+		return note, nil
+	}
+
+	op, err := obj.GetOP()
+
+	if err != nil {
+		return note, util.MakeError(err, "Interop")
+	}
+
+	content := strings.ReplaceAll(obj.Content, "&lt;", "<")
+
+	note.AttributedTo = obj.Actor
+	note.Name = obj.Name
+	note.Content = RenderContentHTML(content)
+	note.Source = &InteropSource{Content: content, MediaType: "text/plain"}
+	note.ThreadId = op
+	note.Published = &obj.Published
+	note.Updated = obj.Updated
+	note.To = []string{publicAddress}
+	note.Cc = []string{obj.Actor + "/followers"}
+	note.Sensitive = obj.Sensitive
+	note.Poster = &obj.AttributedTo
+	note.TripCode = obj.TripCode
+	note.Alias = obj.Alias
+	note.Sticky = obj.Sticky
+	note.Locked = obj.Locked
+
+	if op != obj.Id {
+		note.InReplyTo = op
+
+		for _, e := range obj.InReplyTo {
+			if e.Id != op && e.Id != "" {
+				note.InReplyTo = e.Id
+				break
+			}
+		}
+	}
+
+	for _, e := range obj.Attachment {
+		if e.Href == "" {
+			continue
+		}
+
+		note.Attachment = append(note.Attachment, InteropDocument{
+			Type:      "Document",
+			MediaType: e.MediaType,
+			Url:       e.Href,
+			Name:      e.Name,
+		})
+	}
+
+	if obj.Replies != nil {
+		note.Replies = &InteropReplies{
+			Type:       "OrderedCollection",
+			TotalItems: obj.Replies.TotalItems,
+			TotalImgs:  obj.Replies.TotalImgs,
+		}
+
+		for _, e := range obj.Replies.OrderedItems {
+			reply, err := e.Interop()
+
+			if err != nil {
+				return note, util.MakeError(err, "Interop")
+			}
+
+			reply.Context = nil
+			note.Replies.OrderedItems = append(note.Replies.OrderedItems, reply)
+		}
+	}
+
+	return note, nil
+}
+
+// RenderContentHTML renders a plain comment as HTML with greentext and
+// quote links, roughly as the board shows it.
+func RenderContentHTML(content string) string {
+	var paragraphs []string
+	var lines []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				paragraphs = append(paragraphs, "<p>"+strings.Join(lines, "<br>")+"</p>")
+				lines = nil
+			}
+			continue
+		}
+
+		line = html.EscapeString(strings.TrimRight(line, "\r"))
+		line = interopQuoteLink.ReplaceAllStringFunc(line, func(match string) string {
+			id := strings.TrimPrefix(match, "&gt;&gt;")
+			return `<a href="` + id + `" class="reply">&gt;&gt;` + path.Base(id) + `</a>`
+		})
+
+		if strings.HasPrefix(line, "&gt;") && !strings.HasPrefix(line, "&gt;&gt;") && !strings.HasPrefix(line, `<a `) {
+			line = `<span class="quote">` + line + `</span>`
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(lines) > 0 {
+		paragraphs = append(paragraphs, "<p>"+strings.Join(lines, "<br>")+"</p>")
+	}
+
+	return strings.Join(paragraphs, "")
+}
+
+// IsLegacyAccept reports whether a fetch asked for ActivityStreams only, the
+// one Accept header FChannel instances from before InteropNote send. They
+// read inReplyTo as a list and attributedTo as the poster's name, so they
+// are served posts in the shape they always got. Instances that read
+// InteropNotes add the FChannel profile to their Accept header.
+func IsLegacyAccept(accept string) bool {
+	return strings.TrimSpace(accept) == config.ActivityStreams
+}
+
+// GetInteropNote loads one of our posts as an InteropNote.
+func GetInteropNote(id string) (InteropNote, error) {
+	col, err := ObjectBase{Id: id}.GetCollectionLocal()
+
+	if err != nil {
+		return InteropNote{}, util.MakeError(err, "GetInteropNote")
+	}
+
+	// Deleted posts are no longer Notes
+	if len(col.OrderedItems) == 0 {
+		return ObjectBase{Id: id, Type: "Tombstone"}.Interop()
+	}
+
+	return col.OrderedItems[0].Interop()
+}
@@ -0,0 +1,30 @@
+package activitypub
+
+import (
+	"testing"
+
+	"github.com/anomalous69/fchannel/config"
+)
+
+func TestIsLegacyAccept(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{name: "older fchannel", accept: `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, want: true},
+		{name: "fchannel", accept: config.FetchAccept, want: false},
+		{name: "mastodon", accept: `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams", text/html;q=0.1`, want: false},
+		{name: "misskey", accept: `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`, want: false},
+		{name: "lemmy", accept: `application/activity+json`, want: false},
+		{name: "none", accept: ``, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLegacyAccept(tt.accept); got != tt.want {
+				t.Errorf("IsLegacyAccept(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
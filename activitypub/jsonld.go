@@ -0,0 +1,377 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+)
+
+// A ParseError is returned for a document that cannot be read as an
+// ActivityStreams activity, naming the field at fault where there is one.
+type ParseError struct {
+	Field string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return "malformed activity: " + e.Err.Error()
+	}
+
+	return "malformed activity: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ErrNoActivityStreams is wrapped in the ParseError for a document without
+// the ActivityStreams context. Inboxes ignore these, as they always have.
+var ErrNoActivityStreams = errors.New("no ActivityStreams context")
+
+// Namespaces whose terms are compacted to the names the structs use.
+const (
+	activityStreamsNS = "https://www.w3.org/ns/activitystreams#"
+	securityNS        = "https://w3id.org/security#"
+)
+
+var compactNamespaces = []string{
+	activityStreamsNS,
+	"http://www.w3.org/ns/activitystreams#",
+	securityNS,
+	"http://joinmastodon.org/ns#",
+}
+
+var activityStreamsContexts = []string{
+	"https://www.w3.org/ns/activitystreams",
+	"http://www.w3.org/ns/activitystreams",
+	"https://www.w3.org/ns/activitystreams.jsonld",
+}
+
+// Properties that hold a set, which compaction of an expanded document
+// leaves as an array even with a single value.
+var setTerms = []string{"to", "cc", "bto", "bcc", "audience", "attachment", "tag", "inReplyTo", "items", "orderedItems", "alsoKnownAs", "assertionMethod"}
+
+// Audience properties, where the public collection may be given compacted.
+var audienceTerms = []string{"to", "cc", "bto", "bcc", "audience"}
+
+// jsonldContext is the active context while a document is compacted, the
+// terms its @context defines and the vocabulary plain keys belong to.
+type jsonldContext struct {
+	terms map[string]string
+	vocab string
+}
+
+// jsonldNormalizer compacts a document against the ActivityStreams and
+// security contexts. Remote contexts are not fetched, their terms are taken
+// to be the ones the structs already use.
+type jsonldNormalizer struct {
+	activityStreams bool
+}
+
+// NormalizeJSON rewrites a JSON-LD document into the compact form the
+// structs unmarshal: terms an inline @context defines, prefixed and full
+// IRIs and keyword aliases become the plain ActivityStreams names, value
+// objects are unwrapped and expanded documents are compacted. The result
+// carries the ActivityStreams context only when the document was in it.
+func NormalizeJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, &ParseError{Err: err}
+	}
+
+	// Expanded documents are a list of nodes
+	if list, ok := doc.([]interface{}); ok {
+		if len(list) != 1 {
+			return nil, &ParseError{Err: errors.New("expected a single document")}
+		}
+
+		doc = list[0]
+	}
+
+	node, ok := doc.(map[string]interface{})
+
+	if !ok {
+		return nil, &ParseError{Err: errors.New("not a JSON object")}
+	}
+
+	var n jsonldNormalizer
+
+	compacted, err := n.compactNode(jsonldContext{}, node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n.activityStreams {
+		compacted["@context"] = activityStreamsContexts[0]
+	}
+
+	return json.Marshal(compacted)
+}
+
+// withContext returns the active context with a @context applied to it.
+func (n *jsonldNormalizer) withContext(c jsonldContext, context interface{}) (jsonldContext, error) {
+	switch context := context.(type) {
+	case nil:
+		return jsonldContext{}, nil
+	case string:
+		for _, e := range activityStreamsContexts {
+			if context == e {
+				n.activityStreams = true
+			}
+		}
+
+		return c, nil
+	case []interface{}:
+		var err error
+
+		for _, e := range context {
+			if c, err = n.withContext(c, e); err != nil {
+				return c, err
+			}
+		}
+
+		return c, nil
+	case map[string]interface{}:
+		terms := make(map[string]string, len(c.terms)+len(context))
+
+		for k, v := range c.terms {
+			terms[k] = v
+		}
+
+		nc := jsonldContext{terms: terms, vocab: c.vocab}
+
+		for k, v := range context {
+			if k == "@vocab" {
+				nc.vocab = ""
+				if vocab, ok := v.(string); ok && vocab != "" {
+					nc.vocab = expandPrefix(nc, vocab)
+				}
+
+				continue
+			}
+
+			// @language, @version and the like do not define terms
+			if strings.HasPrefix(k, "@") {
+				continue
+			}
+
+			switch v := v.(type) {
+			case nil:
+				delete(terms, k)
+			case string:
+				terms[k] = v
+			case map[string]interface{}:
+				if id, ok := v["@id"].(string); ok {
+					terms[k] = id
+				} else if _, ok := v["@type"]; ok {
+					// A type coercion for a term the remote context defines
+					continue
+				} else {
+					return c, &ParseError{Field: "@context", Err: errors.New("definition of " + k + " has no @id")}
+				}
+			default:
+				return c, &ParseError{Field: "@context", Err: errors.New("definition of " + k + " is not a string or object")}
+			}
+		}
+
+		return nc, nil
+	}
+
+	return c, &ParseError{Field: "@context", Err: errors.New("not a string, object or array")}
+}
+
+// expandIRI resolves a term, a compact IRI or a keyword alias to what it
+// stands for. Keys no context defines are left as they are.
+func (n *jsonldNormalizer) expandIRI(c jsonldContext, key string) string {
+	if strings.HasPrefix(key, "@") {
+		return key
+	}
+
+	iri := key
+
+	if t, ok := c.terms[key]; ok {
+		iri = expandPrefix(c, t)
+	} else if strings.Contains(key, ":") {
+		iri = expandPrefix(c, key)
+	} else if c.vocab != "" {
+		iri = c.vocab + key
+	}
+
+	if strings.HasPrefix(iri, compactNamespaces[0]) || strings.HasPrefix(iri, compactNamespaces[1]) {
+		n.activityStreams = true
+	}
+
+	return iri
+}
+
+// expandPrefix resolves a compact IRI, absolute IRIs are returned as they
+// are.
+func expandPrefix(c jsonldContext, iri string) string {
+	prefix, suffix, ok := strings.Cut(iri, ":")
+
+	if !ok || strings.HasPrefix(suffix, "//") {
+		return iri
+	}
+
+	if p, ok := c.terms[prefix]; ok && p != iri {
+		return p + suffix
+	}
+
+	if ns := defaultPrefix(prefix); ns != "" {
+		return ns + suffix
+	}
+
+	return iri
+}
+
+// defaultPrefix returns the namespace of the prefixes the ActivityStreams
+// and security contexts define, which documents use without defining them.
+func defaultPrefix(prefix string) string {
+	switch prefix {
+	case "as":
+		return activityStreamsNS
+	case "sec":
+		return securityNS
+	}
+
+	return ""
+}
+
+// compactIRI returns the name a key or type has in the structs.
+func compactIRI(iri string) string {
+	switch iri {
+	case "@id":
+		return "id"
+	case "@type":
+		return "type"
+	}
+
+	for _, e := range compactNamespaces {
+		if term, ok := strings.CutPrefix(iri, e); ok && term != "" {
+			return term
+		}
+	}
+
+	return iri
+}
+
+func (n *jsonldNormalizer) compactNode(c jsonldContext, node map[string]interface{}) (map[string]interface{}, error) {
+	if context, ok := node["@context"]; ok {
+		var err error
+		if c, err = n.withContext(c, context); err != nil {
+			return nil, err
+		}
+	}
+
+	compacted := make(map[string]interface{}, len(node))
+
+	for k, v := range node {
+		if k == "@context" {
+			continue
+		}
+
+		iri := n.expandIRI(c, k)
+		key := compactIRI(iri)
+
+		if _, ok := compacted[key]; ok {
+			return nil, &ParseError{Field: key, Err: errors.New("given more than once")}
+		}
+
+		value, err := n.compactValue(c, v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Expanded documents put every value in an array
+		if list, ok := value.([]interface{}); ok && len(list) == 1 && k != key && !slices.Contains(setTerms, key) {
+			value = list[0]
+		}
+
+		switch {
+		case key == "type":
+			value = n.compactType(c, value)
+		case slices.Contains(audienceTerms, key):
+			value = expandPublic(value)
+		}
+
+		compacted[key] = value
+	}
+
+	return compacted, nil
+}
+
+func (n *jsonldNormalizer) compactValue(c jsonldContext, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if value, ok := v["@value"]; ok {
+			return value, nil
+		}
+
+		// A reference to a node is given by its id alone
+		if id, ok := v["@id"].(string); ok && len(v) == 1 {
+			return id, nil
+		}
+
+		for _, e := range []string{"@list", "@set"} {
+			if list, ok := v[e]; ok {
+				return n.compactValue(c, list)
+			}
+		}
+
+		return n.compactNode(c, v)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+
+		for _, e := range v {
+			value, err := n.compactValue(c, e)
+
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, value)
+		}
+
+		return list, nil
+	}
+
+	return v, nil
+}
+
+// compactType compacts type names, which are resolved like keys.
+func (n *jsonldNormalizer) compactType(c jsonldContext, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return compactIRI(n.expandIRI(c, v))
+	case []interface{}:
+		for i, e := range v {
+			v[i] = n.compactType(c, e)
+		}
+	}
+
+	return v
+}
+
+// expandPublic writes the public collection out in full, as the rest of the
+// code compares against it.
+func expandPublic(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if v == "as:Public" || v == "Public" {
+			return publicAddress
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = expandPublic(e)
+		}
+	}
+
+	return v
+}
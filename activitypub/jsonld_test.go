@@ -0,0 +1,652 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestNormalizeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "compact document",
+			in: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/1",
+				"type": "Follow",
+				"actor": "https://a.example/users/alice",
+				"object": "https://b.example/g"
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/1",
+				"type": "Follow",
+				"actor": "https://a.example/users/alice",
+				"object": "https://b.example/g"
+			}`,
+		},
+		{
+			name: "expanded document",
+			in: `[{
+				"@id": "https://a.example/activities/1",
+				"@type": ["https://www.w3.org/ns/activitystreams#Create"],
+				"https://www.w3.org/ns/activitystreams#actor": [{"@id": "https://a.example/users/alice"}],
+				"https://www.w3.org/ns/activitystreams#to": [{"@id": "https://www.w3.org/ns/activitystreams#Public"}],
+				"https://www.w3.org/ns/activitystreams#object": [{
+					"@id": "https://a.example/notes/1",
+					"@type": ["https://www.w3.org/ns/activitystreams#Note"],
+					"https://www.w3.org/ns/activitystreams#content": [{"@value": "hello"}],
+					"https://www.w3.org/ns/activitystreams#published": [{
+						"@type": "http://www.w3.org/2001/XMLSchema#dateTime",
+						"@value": "2024-05-01T12:00:00Z"
+					}]
+				}]
+			}]`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/1",
+				"type": "Create",
+				"actor": "https://a.example/users/alice",
+				"to": ["https://www.w3.org/ns/activitystreams#Public"],
+				"object": {
+					"id": "https://a.example/notes/1",
+					"type": "Note",
+					"content": "hello",
+					"published": "2024-05-01T12:00:00Z"
+				}
+			}`,
+		},
+		{
+			name: "expanded http namespace",
+			in: `{
+				"@id": "https://a.example/activities/2",
+				"@type": "http://www.w3.org/ns/activitystreams#Delete",
+				"http://www.w3.org/ns/activitystreams#object": {"@id": "https://a.example/notes/1"}
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/2",
+				"type": "Delete",
+				"object": "https://a.example/notes/1"
+			}`,
+		},
+		{
+			name: "aliased id and type",
+			in: `{
+				"@context": ["https://www.w3.org/ns/activitystreams", {"identifier": "@id", "kind": "@type"}],
+				"identifier": "https://a.example/activities/3",
+				"kind": "Like",
+				"actor": "https://a.example/users/alice",
+				"object": {"identifier": "https://b.example/g/1", "kind": "Note"}
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/3",
+				"type": "Like",
+				"actor": "https://a.example/users/alice",
+				"object": {"id": "https://b.example/g/1", "type": "Note"}
+			}`,
+		},
+		{
+			name: "prefixed terms",
+			in: `{
+				"@context": ["https://www.w3.org/ns/activitystreams", {"activity": "https://www.w3.org/ns/activitystreams#"}],
+				"id": "https://a.example/users/alice",
+				"type": "as:Person",
+				"as:inbox": "https://a.example/users/alice/inbox",
+				"activity:preferredUsername": "alice",
+				"sec:publicKey": {
+					"id": "https://a.example/users/alice#main-key",
+					"sec:owner": "https://a.example/users/alice",
+					"sec:publicKeyPem": "-----BEGIN PUBLIC KEY-----"
+				}
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/users/alice",
+				"type": "Person",
+				"inbox": "https://a.example/users/alice/inbox",
+				"preferredUsername": "alice",
+				"publicKey": {
+					"id": "https://a.example/users/alice#main-key",
+					"owner": "https://a.example/users/alice",
+					"publicKeyPem": "-----BEGIN PUBLIC KEY-----"
+				}
+			}`,
+		},
+		{
+			name: "vocab",
+			in: `{
+				"@context": {"@vocab": "https://www.w3.org/ns/activitystreams#"},
+				"id": "https://a.example/activities/4",
+				"type": "Undo",
+				"object": "https://a.example/activities/3"
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/activities/4",
+				"type": "Undo",
+				"object": "https://a.example/activities/3"
+			}`,
+		},
+		{
+			name: "public collection",
+			in: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/notes/2",
+				"type": "Note",
+				"to": "as:Public",
+				"cc": ["Public", "https://a.example/users/alice/followers"]
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/notes/2",
+				"type": "Note",
+				"to": "https://www.w3.org/ns/activitystreams#Public",
+				"cc": ["https://www.w3.org/ns/activitystreams#Public", "https://a.example/users/alice/followers"]
+			}`,
+		},
+		{
+			name: "set-valued terms",
+			in: `{
+				"@id": "https://a.example/notes/3",
+				"@type": ["https://www.w3.org/ns/activitystreams#Note"],
+				"https://www.w3.org/ns/activitystreams#name": [{"@value": "a name"}],
+				"https://www.w3.org/ns/activitystreams#cc": [{"@id": "https://a.example/users/alice/followers"}],
+				"https://www.w3.org/ns/activitystreams#inReplyTo": [{"@id": "https://b.example/g/1"}],
+				"https://www.w3.org/ns/activitystreams#tag": [{
+					"@type": ["https://www.w3.org/ns/activitystreams#Mention"],
+					"https://www.w3.org/ns/activitystreams#href": [{"@id": "https://b.example/g"}]
+				}],
+				"https://www.w3.org/ns/activitystreams#attachment": {"@list": [{
+					"@type": "https://www.w3.org/ns/activitystreams#Document",
+					"https://www.w3.org/ns/activitystreams#url": [{"@id": "https://a.example/media/1.png"}]
+				}]}
+			}`,
+			want: `{
+				"@context": "https://www.w3.org/ns/activitystreams",
+				"id": "https://a.example/notes/3",
+				"type": "Note",
+				"name": "a name",
+				"cc": ["https://a.example/users/alice/followers"],
+				"inReplyTo": ["https://b.example/g/1"],
+				"tag": [{"type": "Mention", "href": "https://b.example/g"}],
+				"attachment": [{"type": "Document", "url": "https://a.example/media/1.png"}]
+			}`,
+		},
+		{
+			name: "no context",
+			in: `{
+				"id": "https://a.example/activities/5",
+				"type": "Follow",
+				"actor": "https://a.example/users/alice"
+			}`,
+			want: `{
+				"id": "https://a.example/activities/5",
+				"type": "Follow",
+				"actor": "https://a.example/users/alice"
+			}`,
+		},
+		{
+			name: "other vocabulary",
+			in: `{
+				"@context": {"schema": "http://schema.org/"},
+				"id": "https://a.example/things/1",
+				"type": "schema:Thing",
+				"schema:name": "thing"
+			}`,
+			want: `{
+				"id": "https://a.example/things/1",
+				"type": "http://schema.org/Thing",
+				"http://schema.org/name": "thing"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeJSON([]byte(tt.in))
+
+			if err != nil {
+				t.Fatalf("NormalizeJSON() error = %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestNormalizeJSONErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		field string
+	}{
+		{
+			name:  "duplicate id",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "id": "https://a.example/1", "@id": "https://a.example/2", "type": "Note"}`,
+			field: "id",
+		},
+		{
+			name:  "duplicate aliased type",
+			in:    `{"@context": ["https://www.w3.org/ns/activitystreams", {"kind": "@type"}], "type": "Note", "kind": "Page"}`,
+			field: "type",
+		},
+		{
+			name:  "duplicate prefixed term",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Follow", "actor": "https://a.example/users/alice", "as:actor": "https://evil.example/users/mallory"}`,
+			field: "actor",
+		},
+		{
+			name:  "duplicate full iri",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Delete", "object": "https://a.example/1", "https://www.w3.org/ns/activitystreams#object": "https://a.example/2"}`,
+			field: "object",
+		},
+		{
+			name:  "duplicate in nested object",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Create", "object": {"type": "Note", "content": "a", "as:content": "b"}}`,
+			field: "content",
+		},
+		{
+			name:  "term without id",
+			in:    `{"@context": ["https://www.w3.org/ns/activitystreams", {"poster": {"@container": "@set"}}], "type": "Note"}`,
+			field: "@context",
+		},
+		{
+			name:  "context of a number",
+			in:    `{"@context": 1, "type": "Note"}`,
+			field: "@context",
+		},
+		{
+			name: "several documents",
+			in:   `[{"@type": "https://www.w3.org/ns/activitystreams#Note"}, {"@type": "https://www.w3.org/ns/activitystreams#Note"}]`,
+		},
+		{
+			name: "not an object",
+			in:   `"https://a.example/1"`,
+		},
+		{
+			name: "not json",
+			in:   `{"type": `,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NormalizeJSON([]byte(tt.in))
+
+			var perr *ParseError
+
+			if !errors.As(err, &perr) {
+				t.Fatalf("NormalizeJSON() error = %v, want a *ParseError", err)
+			}
+
+			if perr.Field != tt.field {
+				t.Errorf("ParseError.Field = %q, want %q", perr.Field, tt.field)
+			}
+		})
+	}
+}
+
+// Activities as Mastodon, Lemmy and Misskey deliver them.
+const (
+	mastodonCreate = `{
+		"@context": [
+			"https://www.w3.org/ns/activitystreams",
+			{
+				"ostatus": "http://ostatus.org#",
+				"atomUri": "ostatus:atomUri",
+				"inReplyToAtomUri": "ostatus:inReplyToAtomUri",
+				"conversation": "ostatus:conversation",
+				"sensitive": "as:sensitive",
+				"toot": "http://joinmastodon.org/ns#",
+				"votersCount": "toot:votersCount",
+				"blurhash": "toot:blurhash",
+				"focalPoint": {"@container": "@list", "@id": "toot:focalPoint"},
+				"Hashtag": "as:Hashtag"
+			}
+		],
+		"id": "https://mastodon.example/users/alice/statuses/112345/activity",
+		"type": "Create",
+		"actor": "https://mastodon.example/users/alice",
+		"published": "2024-05-01T12:00:00Z",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"],
+		"cc": ["https://mastodon.example/users/alice/followers", "https://fchan.example/g"],
+		"object": {
+			"id": "https://mastodon.example/users/alice/statuses/112345",
+			"type": "Note",
+			"summary": null,
+			"inReplyTo": "https://fchan.example/g/ABCD1234",
+			"published": "2024-05-01T12:00:00Z",
+			"url": "https://mastodon.example/@alice/112345",
+			"attributedTo": "https://mastodon.example/users/alice",
+			"to": ["https://www.w3.org/ns/activitystreams#Public"],
+			"cc": ["https://mastodon.example/users/alice/followers", "https://fchan.example/g"],
+			"sensitive": false,
+			"atomUri": "https://mastodon.example/users/alice/statuses/112345",
+			"inReplyToAtomUri": "https://fchan.example/g/ABCD1234",
+			"conversation": "tag:fchan.example,2024-05-01:objectId=1:objectType=Conversation",
+			"content": "<p><span class=\"h-card\"><a href=\"https://fchan.example/g\" class=\"u-url mention\">@<span>g</span></a></span> hello</p>",
+			"contentMap": {"en": "<p>hello</p>"},
+			"attachment": [
+				{
+					"type": "Document",
+					"mediaType": "image/png",
+					"url": "https://files.mastodon.example/media/1.png",
+					"name": null,
+					"blurhash": "UBL_:rOpGG-oBUNG,qRj2so|=eE1w^n4S5NH",
+					"focalPoint": [0.0, 0.0],
+					"width": 640,
+					"height": 480
+				}
+			],
+			"tag": [{"type": "Mention", "href": "https://fchan.example/g", "name": "@g@fchan.example"}],
+			"replies": {
+				"id": "https://mastodon.example/users/alice/statuses/112345/replies",
+				"type": "Collection",
+				"first": {
+					"type": "CollectionPage",
+					"next": "https://mastodon.example/users/alice/statuses/112345/replies?only_other_accounts=true&page=true",
+					"partOf": "https://mastodon.example/users/alice/statuses/112345/replies",
+					"items": []
+				}
+			}
+		},
+		"signature": {
+			"type": "RsaSignature2017",
+			"creator": "https://mastodon.example/users/alice#main-key",
+			"created": "2024-05-01T12:00:00Z",
+			"signatureValue": "c2lnbmF0dXJl"
+		}
+	}`
+
+	lemmyCreate = `{
+		"@context": [
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+			{
+				"lemmy": "https://join-lemmy.org/ns#",
+				"litepub": "http://litepub.social/ns#",
+				"pt": "https://joinpeertube.org/ns#",
+				"sc": "http://schema.org/",
+				"ChatMessage": "litepub:ChatMessage",
+				"commentsEnabled": "pt:commentsEnabled",
+				"sensitive": "as:sensitive",
+				"matrixUserId": "lemmy:matrixUserId",
+				"postingRestrictedToMods": "lemmy:postingRestrictedToMods",
+				"removeData": "lemmy:removeData",
+				"stickied": "lemmy:stickied",
+				"moderators": {"@type": "@id", "@id": "lemmy:moderators"},
+				"expires": "as:endTime",
+				"distinguished": "lemmy:distinguished",
+				"language": "sc:inLanguage",
+				"identifier": "sc:identifier"
+			}
+		],
+		"actor": "https://lemmy.example/u/bob",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"],
+		"object": {
+			"type": "Note",
+			"id": "https://lemmy.example/comment/42",
+			"attributedTo": "https://lemmy.example/u/bob",
+			"to": ["https://www.w3.org/ns/activitystreams#Public"],
+			"cc": ["https://fchan.example/g", "https://fchan.example/g/ABCD1234"],
+			"content": "<p>a reply</p>\n",
+			"inReplyTo": "https://fchan.example/g/ABCD1234",
+			"mediaType": "text/html",
+			"source": {"content": "a reply", "mediaType": "text/markdown"},
+			"tag": [{"href": "https://fchan.example/g", "type": "Mention", "name": "@g@fchan.example"}],
+			"distinguished": false,
+			"language": {"identifier": "en", "name": "English"},
+			"audience": "https://fchan.example/g",
+			"published": "2024-05-01T12:00:00.123456+00:00"
+		},
+		"cc": ["https://fchan.example/g"],
+		"tag": [{"href": "https://fchan.example/g", "type": "Mention", "name": "@g@fchan.example"}],
+		"type": "Create",
+		"id": "https://lemmy.example/activities/create/0b6b2e3a-3c1f-4a3b-9d4e-1f2a3b4c5d6e",
+		"audience": "https://fchan.example/g"
+	}`
+
+	misskeyCreate = `{
+		"@context": [
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+			{
+				"Key": "sec:Key",
+				"manuallyApprovesFollowers": "as:manuallyApprovesFollowers",
+				"sensitive": "as:sensitive",
+				"Hashtag": "as:Hashtag",
+				"quoteUrl": "as:quoteUrl",
+				"toot": "http://joinmastodon.org/ns#",
+				"Emoji": "toot:Emoji",
+				"featured": "toot:featured",
+				"discoverable": "toot:discoverable",
+				"schema": "http://schema.org#",
+				"PropertyValue": "schema:PropertyValue",
+				"value": "schema:value",
+				"misskey": "https://misskey-hub.net/ns#",
+				"_misskey_content": "misskey:_misskey_content",
+				"_misskey_quote": "misskey:_misskey_quote",
+				"_misskey_reaction": "misskey:_misskey_reaction",
+				"_misskey_votes": "misskey:_misskey_votes",
+				"_misskey_summary": "misskey:_misskey_summary",
+				"isCat": "misskey:isCat",
+				"vcard": "http://www.w3.org/2006/vcard/ns#"
+			}
+		],
+		"id": "https://misskey.example/notes/9tq1z2x3y4/activity",
+		"actor": "https://misskey.example/users/9abcdef",
+		"type": "Create",
+		"published": "2024-05-01T12:00:00.000Z",
+		"object": {
+			"id": "https://misskey.example/notes/9tq1z2x3y4",
+			"type": "Note",
+			"attributedTo": "https://misskey.example/users/9abcdef",
+			"content": "<p>hello from misskey</p>",
+			"_misskey_content": "hello from misskey",
+			"source": {"content": "hello from misskey", "mediaType": "text/x.misskeymarkdown"},
+			"quoteUrl": null,
+			"_misskey_quote": null,
+			"published": "2024-05-01T12:00:00.000Z",
+			"to": ["https://www.w3.org/ns/activitystreams#Public"],
+			"cc": ["https://misskey.example/users/9abcdef/followers"],
+			"inReplyTo": null,
+			"attachment": [],
+			"sensitive": false,
+			"tag": []
+		},
+		"to": ["https://www.w3.org/ns/activitystreams#Public"],
+		"cc": ["https://misskey.example/users/9abcdef/followers"]
+	}`
+)
+
+func TestGetActivityFromJson(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		typ       string
+		actor     string
+		object    string
+		content   string
+		inReplyTo string
+	}{
+		{
+			name:      "mastodon",
+			in:        mastodonCreate,
+			typ:       "Create",
+			actor:     "https://mastodon.example/users/alice",
+			object:    "https://mastodon.example/users/alice/statuses/112345",
+			content:   "hello</p>",
+			inReplyTo: "https://fchan.example/g/ABCD1234",
+		},
+		{
+			name:      "lemmy",
+			in:        lemmyCreate,
+			typ:       "Create",
+			actor:     "https://lemmy.example/u/bob",
+			object:    "https://lemmy.example/comment/42",
+			content:   "<p>a reply</p>",
+			inReplyTo: "https://fchan.example/g/ABCD1234",
+		},
+		{
+			name:    "misskey",
+			in:      misskeyCreate,
+			typ:     "Create",
+			actor:   "https://misskey.example/users/9abcdef",
+			object:  "https://misskey.example/notes/9tq1z2x3y4",
+			content: "<p>hello from misskey</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activity, err := postActivity(t, tt.in)
+
+			if err != nil {
+				t.Fatalf("GetActivityFromJson() error = %v", err)
+			}
+
+			if activity.Type != tt.typ {
+				t.Errorf("Type = %q, want %q", activity.Type, tt.typ)
+			}
+
+			if activity.Actor == nil || activity.Actor.Id != tt.actor {
+				t.Errorf("Actor = %+v, want %q", activity.Actor, tt.actor)
+			}
+
+			if !slices.Contains(activity.To, publicAddress) {
+				t.Errorf("To = %q, want it to contain %q", activity.To, publicAddress)
+			}
+
+			obj := activity.Object
+
+			if obj.Id != tt.object || obj.Type != "Note" {
+				t.Errorf("Object = %q %q, want %q Note", obj.Id, obj.Type, tt.object)
+			}
+
+			if obj.AttributedTo != tt.actor {
+				t.Errorf("Object.AttributedTo = %q, want %q", obj.AttributedTo, tt.actor)
+			}
+
+			if !strings.Contains(obj.Content, tt.content) {
+				t.Errorf("Object.Content = %q, want it to contain %q", obj.Content, tt.content)
+			}
+
+			if obj.Published.IsZero() {
+				t.Error("Object.Published is not set")
+			}
+
+			if tt.inReplyTo == "" {
+				if len(obj.InReplyTo) != 0 {
+					t.Errorf("Object.InReplyTo = %+v, want none", obj.InReplyTo)
+				}
+			} else if len(obj.InReplyTo) == 0 || obj.InReplyTo[0].Id != tt.inReplyTo {
+				t.Errorf("Object.InReplyTo = %+v, want %q", obj.InReplyTo, tt.inReplyTo)
+			}
+		})
+	}
+}
+
+func TestGetActivityFromJsonErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		field string
+		is    error
+	}{
+		{
+			name:  "no context",
+			in:    `{"id": "https://a.example/activities/1", "type": "Follow", "actor": "https://a.example/users/alice", "object": "https://fchan.example/g"}`,
+			field: "@context",
+			is:    ErrNoActivityStreams,
+		},
+		{
+			name:  "other context",
+			in:    `{"@context": "https://schema.org", "id": "https://a.example/activities/1", "type": "Follow"}`,
+			field: "@context",
+			is:    ErrNoActivityStreams,
+		},
+		{
+			name:  "no type",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "id": "https://a.example/activities/1", "actor": "https://a.example/users/alice"}`,
+			field: "type",
+		},
+		{
+			name:  "duplicate actor",
+			in:    `{"@context": "https://www.w3.org/ns/activitystreams", "type": "Follow", "actor": "https://a.example/users/alice", "as:actor": "https://evil.example/users/mallory"}`,
+			field: "actor",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := postActivity(t, tt.in)
+
+			var perr *ParseError
+
+			if !errors.As(err, &perr) {
+				t.Fatalf("GetActivityFromJson() error = %v, want a *ParseError", err)
+			}
+
+			if perr.Field != tt.field {
+				t.Errorf("ParseError.Field = %q, want %q", perr.Field, tt.field)
+			}
+
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("GetActivityFromJson() error = %v, want %v", err, tt.is)
+			}
+		})
+	}
+}
+
+// postActivity reads body with GetActivityFromJson as an inbox would.
+func postActivity(t *testing.T, body string) (Activity, error) {
+	t.Helper()
+
+	var activity Activity
+	var err error
+
+	app := fiber.New()
+	app.Post("/inbox", func(ctx *fiber.Ctx) error {
+		activity, err = GetActivityFromJson(ctx)
+		return nil
+	})
+
+	req := httptest.NewRequest("POST", "/inbox", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+
+	if _, terr := app.Test(req); terr != nil {
+		t.Fatal(terr)
+	}
+
+	return activity, err
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w interface{}
+
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("invalid test JSON: %v", err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,298 @@
+package activitypub
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// Longest comment the cache keeps, content is stored escaped in a
+// varchar(4500) column, the same limit posting has.
+const maxCachedContent = 4500
+
+// Posting allows subjects up to this long.
+const maxSubject = 100
+
+var htmlLineBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+var htmlParagraph = regexp.MustCompile(`(?i)</p>\s*<p[^>]*>`)
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// IsBoard reports whether an actor is a board rather than a user account on
+// microblog software. Actors only known by id are taken to be boards.
+func (actor Actor) IsBoard() bool {
+	return actor.Type == "" || actor.Type == "Group"
+}
+
+// ProcessPersonCreate takes a post from a user account onto the board. The
+// board has to allow posts from users, replies need the thread, either its
+// own or one from a board it follows, and only followers may start threads.
+// Whatever lands on one of the board's own threads is announced to its
+// followers.
+func (actor Actor) ProcessPersonCreate(activity Activity) error {
+	query := `select optionsmask from actor where id=$1`
+	if err := config.DB.QueryRow(query, actor.Id).Scan(&actor.OptionsMask); err != nil {
+		return util.MakeError(err, "ProcessPersonCreate")
+	}
+
+	if !actor.HasOption(OptionUserReplies) || actor.HasOption(OptionReadOnly) {
+		return nil
+	}
+
+	if !IsPostType(activity.Object.Type) {
+		return nil
+	}
+
+	// The note has to live on the instance that signed for it
+	if util.GetInstanceHost(activity.Object.Id) != util.GetInstanceHost(activity.Actor.Id) {
+		return nil
+	}
+
+	if len(activity.Object.InReplyTo) == 0 {
+		if follower, err := actor.IsAlreadyFollower(activity.Actor.Id); err != nil || !follower {
+			return util.MakeError(err, "ProcessPersonCreate")
+		}
+	}
+
+	board, err := actor.CachePost(*activity.Actor, activity.Object, actor.Id)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessPersonCreate")
+	}
+
+	if board != actor.Id {
+		return nil
+	}
+
+	return util.MakeError(actor.Announce(activity), "ProcessPersonCreate")
+}
+
+// CachePost normalizes a post from other software and caches it on the
+// thread it replies to, or as a new thread of owner. It returns the board
+// the post landed on, empty when the thread is unknown, locked or on a board
+// this one does not follow.
+func (actor Actor) CachePost(author Actor, obj ObjectBase, owner string) (string, error) {
+	if col, _ := obj.GetCollectionLocal(); len(col.OrderedItems) != 0 {
+		return "", nil
+	}
+
+	if len(obj.InReplyTo) == 0 {
+		obj = obj.NormalizePost(author, "", "")
+		obj.Actor = owner
+
+		if _, err := obj.WriteCache(); err != nil {
+			return "", util.MakeError(err, "CachePost")
+		}
+
+		if err := obj.WriteCacheSender(author.Id); err != nil {
+			return "", util.MakeError(err, "CachePost")
+		}
+
+		return owner, util.MakeError(actor.ArchivePosts(), "CachePost")
+	}
+
+	target := obj.InReplyTo[0]
+
+	if col, err := target.GetCollectionLocal(); err != nil {
+		return "", util.MakeError(err, "CachePost")
+	} else if len(col.OrderedItems) == 0 {
+		return "", nil
+	}
+
+	op, err := target.GetOP()
+
+	if err != nil {
+		return "", util.MakeError(err, "CachePost")
+	}
+
+	thread, err := ObjectBase{Id: op}.GetCollectionLocal()
+
+	if err != nil {
+		return "", util.MakeError(err, "CachePost")
+	}
+
+	if len(thread.OrderedItems) == 0 || thread.OrderedItems[0].Locked {
+		return "", nil
+	}
+
+	board := thread.OrderedItems[0].Actor
+
+	if board != actor.Id {
+		if following, err := actor.IsFollowing(board); err != nil || !following {
+			return "", util.MakeError(err, "CachePost")
+		}
+	}
+
+	obj = obj.NormalizePost(author, op, target.Id)
+	obj.Actor = board
+
+	if _, err := obj.WriteCache(); err != nil {
+		return "", util.MakeError(err, "CachePost")
+	}
+
+	if err := obj.WriteCacheSender(author.Id); err != nil {
+		return "", util.MakeError(err, "CachePost")
+	}
+
+	return board, nil
+}
+
+// IsPostType reports whether other software's object type is one boards
+// take as a post. Link aggregators send threads as Pages.
+func IsPostType(objType string) bool {
+	return objType == "Note" || objType == "Page" || objType == "Article"
+}
+
+// NormalizePost turns a microblog note or link aggregator page into a post
+// the way our boards store one. A reply has the thread first in inReplyTo
+// and quotes the post it answers, op is empty for a new thread. The HTML is
+// flattened to a plain comment without the mentions prepended for
+// addressing, linked pages go on top and the first attachment is kept.
+func (obj ObjectBase) NormalizePost(sender Actor, op string, target string) ObjectBase {
+	obj.Type = "Note"
+	obj.InReplyTo = nil
+
+	if op != "" {
+		obj.InReplyTo = []ObjectBase{{Id: op}}
+	}
+
+	var mentions []string
+
+	for _, e := range obj.Tag {
+		if e.Type == "Mention" && e.Name != "" {
+			mentions = append(mentions, e.Name)
+		}
+	}
+
+	content := stripMentions(htmlToText(obj.Content), mentions)
+
+	for _, e := range obj.Attachment {
+		if e.Type == "Link" && e.Href != "" {
+			content = e.Href + "\n" + content
+		}
+	}
+
+	if target != op {
+		obj.InReplyTo = append(obj.InReplyTo, ObjectBase{Id: target})
+		content = ">>" + target + "\n" + content
+	}
+
+	obj.Content = truncateContent(strings.TrimSpace(content))
+
+	// Pages have a title, otherwise a content warning is the closest thing
+	// to a subject
+	if obj.Name == "" {
+		obj.Name = obj.Summary
+	}
+	obj.Summary = ""
+
+	if name := []rune(obj.Name); len(name) > maxSubject {
+		obj.Name = string(name[:maxSubject])
+	}
+
+	obj.AttributedTo = sender.PreferredUsername + "@" + util.GetInstanceHost(sender.Id)
+	if sender.PreferredUsername == "" {
+		obj.AttributedTo = sender.Id
+	}
+
+	obj.TripCode = ""
+	obj.Option = nil
+	obj.Tag = nil
+	obj.Replies = nil
+	obj.Preview = nil
+
+	var attachment []ObjectBase
+
+	for _, e := range obj.Attachment {
+		href := e.Href
+
+		if href == "" && len(e.Url) > 0 {
+			href = e.Url[0].Href
+			if href == "" {
+				href = e.Url[0].Id
+			}
+		}
+
+		if href == "" || e.MediaType == "" {
+			continue
+		}
+
+		attachment = append(attachment, ObjectBase{
+			Id:        fmt.Sprintf("%s#attachment-%d", obj.Id, len(attachment)),
+			Type:      "Attachment",
+			Name:      path.Base(href),
+			Href:      href,
+			MediaType: e.MediaType,
+			Size:      e.Size,
+			Published: obj.Published,
+		})
+		break
+	}
+
+	obj.Attachment = attachment
+
+	if len(attachment) > 0 {
+		obj.Preview = &NestedObjectBase{}
+	}
+
+	return obj
+}
+
+func htmlToText(content string) string {
+	content = htmlLineBreak.ReplaceAllString(content, "\n")
+	content = htmlParagraph.ReplaceAllString(content, "\n\n")
+	content = htmlTag.ReplaceAllString(content, "")
+
+	return strings.TrimSpace(html.UnescapeString(content))
+}
+
+// stripMentions drops the mentions microblog software puts in front of a
+// reply to address it.
+func stripMentions(content string, mentions []string) string {
+	for {
+		fields := strings.Fields(content)
+
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "@") {
+			return content
+		}
+
+		matched := false
+		for _, e := range mentions {
+			if e == fields[0] || strings.HasPrefix(e, fields[0]+"@") {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return content
+		}
+
+		content = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(content), fields[0]))
+	}
+}
+
+// truncateContent cuts a comment to what fits once util.EscapeString has
+// turned each < into &lt;.
+func truncateContent(content string) string {
+	var size int
+
+	for i, e := range content {
+		n := 1
+		if e == '<' {
+			n = len("&lt;")
+		}
+
+		if size+n > maxCachedContent {
+			return content[:i]
+		}
+
+		size += n
+	}
+
+	return content
+}
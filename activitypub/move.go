@@ -0,0 +1,258 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// BoardExport is what a board takes along to another instance. Threads are
+// the board's own with their replies. Attachments are only linked where they
+// are hosted now, so they stop loading once the old instance is gone.
+type BoardExport struct {
+	Version   int          `json:"version"`
+	Board     Actor        `json:"board"`
+	Following []string     `json:"following"`
+	Threads   []ObjectBase `json:"threads"`
+	Exported  time.Time    `json:"exported"`
+}
+
+// Move tells followers and followed boards that a board now lives at
+// Target.
+type Move struct {
+	Context string   `json:"@context"`
+	Id      string   `json:"id"`
+	Type    string   `json:"type"`
+	Actor   string   `json:"actor"`
+	Object  string   `json:"object"`
+	Target  string   `json:"target"`
+	To      []string `json:"to"`
+	Cc      []string `json:"cc"`
+}
+
+// Export collects a local board's settings, the boards it follows and its
+// threads.
+func (actor Actor) Export() (BoardExport, error) {
+	export := BoardExport{Version: 1, Exported: time.Now().UTC()}
+
+	board, err := GetActorByNameFromDB(actor.PreferredUsername)
+
+	if err != nil {
+		return export, util.MakeError(err, "Export")
+	}
+
+	board.PublicKey = nil
+
+	if board.AuthRequirement, err = util.GetBoardAuth(board.PreferredUsername); err != nil {
+		return export, util.MakeError(err, "Export")
+	}
+
+	export.Board = board
+
+	following, err := board.GetFollowing()
+
+	if err != nil {
+		return export, util.MakeError(err, "Export")
+	}
+
+	for _, e := range following {
+		if e.Id != config.Domain {
+			export.Following = append(export.Following, e.Id)
+		}
+	}
+
+	col, err := board.GetCollection()
+
+	if err != nil {
+		return export, util.MakeError(err, "Export")
+	}
+
+	export.Threads = col.OrderedItems
+
+	return export, nil
+}
+
+// SetAlsoKnownAs records the ids the board had on other instances. A board
+// only accepts being moved to one that lists where it came from.
+func (actor Actor) SetAlsoKnownAs(ids []string) error {
+	var aliases []string
+
+	for _, e := range ids {
+		e = strings.TrimSuffix(strings.TrimSpace(e), "/")
+
+		if e != "" && e != actor.Id && !slices.Contains(aliases, e) {
+			aliases = append(aliases, e)
+		}
+	}
+
+	query := `update actor set alsoknownas=$1 where id=$2`
+	_, err := config.DB.Exec(query, strings.Join(aliases, " "), actor.Id)
+
+	return util.MakeError(err, "SetAlsoKnownAs")
+}
+
+// Move points the board at its new home and sends a Move to every board it
+// federates with. The new board has to name this one in alsoKnownAs, which
+// importing an export sets up.
+func (actor Actor) Move(target string) error {
+	target = strings.TrimSuffix(strings.TrimSpace(target), "/")
+
+	if target == "" || target == actor.Id {
+		return errors.New("no board to move to")
+	}
+
+	moved, err := RefreshActor(target)
+
+	if err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	if moved.Id != target || !slices.Contains(moved.AlsoKnownAs, actor.Id) {
+		return errors.New(target + " does not list " + actor.Id + " in alsoKnownAs")
+	}
+
+	query := `update actor set movedto=$1 where id=$2`
+	if _, err := config.DB.Exec(query, target, actor.Id); err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	followers, err := actor.GetFollower()
+
+	if err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	following, err := actor.GetFollowing()
+
+	if err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	var to []string
+
+	for _, e := range append(followers, following...) {
+		if local, _ := (Actor{Id: e.Id}).IsLocal(); !local && !slices.Contains(to, e.Id) {
+			to = append(to, e.Id)
+		}
+	}
+
+	// Local boards are told directly, they share this database
+	if err := actor.RewriteMoved(target); err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	if len(to) == 0 {
+		return nil
+	}
+
+	move := Move{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      actor.Id + "#moves/" + util.RandomID(8),
+		Type:    "Move",
+		Actor:   actor.Id,
+		Object:  actor.Id,
+		Target:  target,
+		To:      []string{publicAddress},
+		Cc:      []string{actor.Id + "/followers"},
+	}
+
+	payload, err := json.Marshal(move)
+
+	if err != nil {
+		return util.MakeError(err, "Move")
+	}
+
+	return util.MakeError(DeliverToInboxes(actor.Id, to, payload), "Move")
+}
+
+// ProcessMove follows a remote board to its new id once the new board
+// confirms it in alsoKnownAs.
+func (activity Activity) ProcessMove() error {
+	if activity.Object.Id != activity.Actor.Id || activity.Target == "" || activity.Target == activity.Actor.Id {
+		return nil
+	}
+
+	if !util.IsInstanceAllowed(activity.Target) {
+		return nil
+	}
+
+	target, err := RefreshActor(activity.Target)
+
+	if err != nil {
+		return util.MakeError(err, "ProcessMove")
+	}
+
+	if target.Id != activity.Target || !slices.Contains(target.AlsoKnownAs, activity.Actor.Id) {
+		config.Log.Println(activity.Target + " does not list " + activity.Actor.Id + " in alsoKnownAs, ignoring Move")
+		return nil
+	}
+
+	return util.MakeError(Actor{Id: activity.Actor.Id}.RewriteMoved(target.Id), "ProcessMove")
+}
+
+// RewriteMoved points what this instance keeps about a board at the id it
+// moved to. Local boards following it follow the new board instead, it
+// follows them back from there, and its cached threads are listed under the
+// new board so their history stays readable.
+func (actor Actor) RewriteMoved(target string) error {
+	boards, err := GetLocalFollowers(actor.Id)
+
+	if err != nil {
+		return util.MakeError(err, "RewriteMoved")
+	}
+
+	for _, board := range boards {
+		// The main board only follows boards on this instance
+		if board.Id == target || board.Id == config.Domain {
+			continue
+		}
+
+		if err := board.RemoveFollowing(actor.Id); err != nil {
+			return util.MakeError(err, "RewriteMoved")
+		}
+
+		if following, _ := board.IsFollowing(target); following {
+			continue
+		}
+
+		follow, err := board.MakeFollowActivity(target)
+
+		if err != nil {
+			return util.MakeError(err, "RewriteMoved")
+		}
+
+		if err := follow.MakeRequestOutbox(); err != nil {
+			return util.MakeError(err, "RewriteMoved")
+		}
+	}
+
+	// Drop the old follower where the new board already follows
+	query := `delete from follower where follower=$1 and id in (select id from follower where follower=$2)`
+	if _, err := config.DB.Exec(query, actor.Id, target); err != nil {
+		return util.MakeError(err, "RewriteMoved")
+	}
+
+	query = `update follower set follower=$2 where follower=$1`
+	if _, err := config.DB.Exec(query, actor.Id, target); err != nil {
+		return util.MakeError(err, "RewriteMoved")
+	}
+
+	query = `update cacheactivitystream set actor=$2 where actor=$1`
+	if _, err := config.DB.Exec(query, actor.Id, target); err != nil {
+		return util.MakeError(err, "RewriteMoved")
+	}
+
+	query = `update crosspost set origin=$2 where origin=$1`
+	if _, err := config.DB.Exec(query, actor.Id, target); err != nil {
+		return util.MakeError(err, "RewriteMoved")
+	}
+
+	config.Log.Println(actor.Id + " moved to " + target)
+
+	return util.MakeError(RefreshBoards(), "RewriteMoved")
+}
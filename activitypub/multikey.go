@@ -0,0 +1,133 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+// Multicodec prefix of an Ed25519 public key in a publicKeyMultibase value.
+var ed25519Multicodec = []byte{0xed, 0x01}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func (actor Actor) GetMultikeys() ([]Multikey, error) {
+	var keys []Multikey
+
+	query := `select id, file from publicKeyPem where owner=$1 and type=$2`
+	rows, err := config.DB.Query(query, actor.Id, KeyTypeEd25519)
+	if err != nil {
+		return keys, util.MakeError(err, "GetMultikeys")
+	}
+
+	var ids []string
+
+	defer rows.Close()
+	for rows.Next() {
+		var id, file string
+		rows.Scan(&id, &file)
+		ids = append(ids, id)
+	}
+
+	for _, e := range ids {
+		pem, err := GetActorPemFromDB(e)
+		if err != nil {
+			return keys, util.MakeError(err, "GetMultikeys")
+		}
+
+		pub, err := ParsePublicKeyPem(strings.ReplaceAll(pem.PublicKeyPem, `\n`, "\n"))
+		if err != nil {
+			return keys, util.MakeError(err, "GetMultikeys")
+		}
+
+		edKey, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+
+		keys = append(keys, Multikey{
+			Id:                 e,
+			Type:               "Multikey",
+			Controller:         actor.Id,
+			PublicKeyMultibase: EncodeMultikey(edKey),
+		})
+	}
+
+	return keys, nil
+}
+
+func EncodeMultikey(key ed25519.PublicKey) string {
+	return "z" + base58Encode(append(append([]byte{}, ed25519Multicodec...), key...))
+}
+
+// DecodeMultikey only understands base58btc encoded Ed25519 keys, which is
+// the only kind peers publish in practice.
+func DecodeMultikey(value string) (crypto.PublicKey, error) {
+	if !strings.HasPrefix(value, "z") {
+		return nil, errors.New("unsupported multibase encoding")
+	}
+
+	b, err := base58Decode(value[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) != len(ed25519Multicodec)+ed25519.PublicKeySize || b[0] != ed25519Multicodec[0] || b[1] != ed25519Multicodec[1] {
+		return nil, errors.New("unsupported multikey type")
+	}
+
+	return ed25519.PublicKey(b[len(ed25519Multicodec):]), nil
+}
+
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	for _, e := range b {
+		if e != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+
+	for _, e := range s {
+		i := strings.IndexRune(base58Alphabet, e)
+		if i < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	var zeros int
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -345,6 +345,39 @@ func (obj ObjectBase) DeleteRequest() error {
 	return util.MakeError(err, "DeleteRequest")
 }
 
+// UpdateRequest sends an edited post to the same instances a new post would
+// have gone to.
+func (obj ObjectBase) UpdateRequest() error {
+	nObj, err := obj.GetFromPath()
+
+	if err != nil {
+		return util.MakeError(err, "UpdateRequest")
+	}
+
+	if nObj.InReplyTo, err = nObj.GetInReplyTo(); err != nil {
+		return util.MakeError(err, "UpdateRequest")
+	}
+
+	now := time.Now().UTC()
+	nObj.Updated = &now
+
+	activity, err := nObj.CreateActivity("Update")
+
+	if err != nil {
+		return util.MakeError(err, "UpdateRequest")
+	}
+
+	activity.Published = now
+
+	if activity, err = activity.AddFollowersTo(); err != nil {
+		return util.MakeError(err, "UpdateRequest")
+	}
+
+	err = activity.MakeRequestInbox()
+
+	return util.MakeError(err, "UpdateRequest")
+}
+
 func (obj ObjectBase) DeleteReported() error {
 	query := `delete from reported where id=$1`
 	_, err := config.DB.Exec(query, obj.Id)
@@ -1401,6 +1434,29 @@ func (obj ObjectBase) WriteCache() (ObjectBase, error) {
 	return obj, nil
 }
 
+// WriteCacheSender records who sent a cached post from other software.
+func (obj ObjectBase) WriteCacheSender(sender string) error {
+	query := `update cacheactivitystream set sender=$1 where id=$2`
+	_, err := config.DB.Exec(query, sender, obj.Id)
+	return util.MakeError(err, "WriteCacheSender")
+}
+
+// GetCacheOwner returns the actor that may change a cached post, whoever
+// sent it for posts from other software and otherwise the board. It is
+// empty when the post is not cached.
+func (obj ObjectBase) GetCacheOwner() (string, error) {
+	var owner string
+
+	query := `select case when sender != '' then sender else actor end from cacheactivitystream where id=$1 and type='Note'`
+	if err := config.DB.QueryRow(query, obj.Id).Scan(&owner); err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", util.MakeError(err, "GetCacheOwner")
+	}
+
+	return owner, nil
+}
+
 func (obj ObjectBase) WriteUpdate(updated time.Time) error {
 	query := `update activitystream set updated=$1 where id=$2`
 	if _, err := config.DB.Exec(query, updated, obj.Id); err != nil {
@@ -1412,6 +1468,22 @@ func (obj ObjectBase) WriteUpdate(updated time.Time) error {
 	return util.MakeError(err, "WriteUpdate")
 }
 
+// WriteEdit replaces the subject and comment of a post, attachments can't be
+// changed by an edit.
+func (obj ObjectBase) WriteEdit(updated time.Time) error {
+	obj.Name = util.EscapeString(obj.Name)
+	obj.Content = util.EscapeString(obj.Content)
+
+	query := `update activitystream set name=$1, content=$2, updated=$3 where id=$4 and type='Note'`
+	if _, err := config.DB.Exec(query, obj.Name, obj.Content, updated, obj.Id); err != nil {
+		return util.MakeError(err, "WriteEdit")
+	}
+
+	query = `update cacheactivitystream set name=$1, content=$2, updated=$3 where id=$4 and type='Note'`
+	_, err := config.DB.Exec(query, obj.Name, obj.Content, updated, obj.Id)
+	return util.MakeError(err, "WriteEdit")
+}
+
 func (obj ObjectBase) WriteWithAttachment(attachment ObjectBase) {
 	obj.Name = util.EscapeString(obj.Name)
 	obj.Content = util.EscapeString(obj.Content)
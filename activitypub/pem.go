@@ -1,6 +1,8 @@
 package activitypub
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	crand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -8,12 +10,19 @@ import (
 	"errors"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anomalous69/fchannel/config"
 	"github.com/anomalous69/fchannel/util"
 )
 
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
 type Signature struct {
 	KeyId     string
 	Headers   []string
@@ -70,7 +79,11 @@ func CreatePem(actor Actor) error {
 		config.Log.Println(`Created PEM keypair for the "` + actor.PreferredUsername + `" board. Please keep in mind that
 	the PEM key is crucial in identifying yourself as the legitimate owner of the board,
 	so DO NOT LOSE IT!!! If you lose it, YOU WILL LOSE ACCESS TO YOUR BOARD!`)
-		return StorePemToDB(actor)
+		if err := StorePemToDB(actor); err != nil {
+			return util.MakeError(err, "CreatePem")
+		}
+
+		return CreateEd25519Pem(actor)
 	}
 
 }
@@ -197,6 +210,90 @@ func StorePemToDB(actor Actor) error {
 	return util.MakeError(err, "StorePemToDB")
 }
 
+// RotatePem replaces the main key of an actor with a new keypair. The old key
+// is kept and still accepted for verification until the rotation grace period
+// has passed.
+func RotatePem(actor Actor) (string, error) {
+	privatekey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privatekey.PublicKey)
+	if err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	file := "./pem/board/" + actor.PreferredUsername + "-" + ts + "-public.pem"
+
+	if err := os.WriteFile(strings.ReplaceAll(file, "public.pem", "private.pem"), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privatekey)}), 0600); err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0644); err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	keyId := actor.Id + "#main-key-" + ts
+
+	query := `update publicKeyPem set expires=NOW() + make_interval(secs => $1) where owner=$2 and type=$3 and expires is null`
+	if _, err := config.DB.Exec(query, float64(config.KeyRotationGrace), actor.Id, KeyTypeRSA); err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	query = `insert into publicKeyPem (id, owner, file, type) values($1, $2, $3, $4)`
+	if _, err := config.DB.Exec(query, keyId, actor.Id, file, KeyTypeRSA); err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	query = `update actor set publicKeyPem=$1 where id=$2`
+	if _, err := config.DB.Exec(query, keyId, actor.Id); err != nil {
+		return "", util.MakeError(err, "RotatePem")
+	}
+
+	config.Log.Println(`Rotated the PEM keypair for the "` + actor.PreferredUsername + `" board, the new key is ` + file)
+
+	return keyId, nil
+}
+
+// GetGraceKey returns a key that was rotated out but is still accepted. Local
+// keys keep their publicKeyPem row, remote ones are remembered in remotekey
+// when a refetch shows the actor has a new key.
+func GetGraceKey(keyId string, owner string) (crypto.PublicKey, error) {
+	var publicKeyPem string
+
+	if strings.HasPrefix(owner, config.Domain) {
+		var id string
+
+		query := `select id from publicKeyPem where id=$1 and owner=$2 and expires > NOW()`
+		if err := config.DB.QueryRow(query, keyId, owner).Scan(&id); err != nil {
+			return nil, util.MakeError(err, "GetGraceKey")
+		}
+
+		pem, err := GetActorPemFromDB(id)
+		if err != nil {
+			return nil, util.MakeError(err, "GetGraceKey")
+		}
+
+		publicKeyPem = strings.ReplaceAll(pem.PublicKeyPem, `\n`, "\n")
+	} else {
+		query := `select pem from remotekey where id=$1 and owner=$2 and expires > NOW()`
+		if err := config.DB.QueryRow(query, keyId, owner).Scan(&publicKeyPem); err != nil {
+			return nil, util.MakeError(err, "GetGraceKey")
+		}
+	}
+
+	return ParsePublicKeyPem(publicKeyPem)
+}
+
+func StoreRemoteKey(key PublicKeyPem) error {
+	query := `insert into remotekey (id, owner, pem, expires) values ($1, $2, $3, NOW() + make_interval(secs => $4)) on conflict (id) do update set pem=$3, expires=NOW() + make_interval(secs => $4)`
+	_, err := config.DB.Exec(query, key.Id, key.Owner, key.PublicKeyPem, float64(config.KeyRotationGrace))
+
+	return util.MakeError(err, "StoreRemoteKey")
+}
+
 func ParseHeaderSignature(signature string) Signature {
 	var nsig Signature
 
@@ -233,3 +330,113 @@ func ParseHeaderSignature(signature string) Signature {
 
 	return nsig
 }
+
+// CreateEd25519Pem generates the Ed25519 keypair published as a Multikey in
+// the actors assertionMethod. The RSA main key stays the publicKey so peers
+// that only know the draft signatures keep working.
+func CreateEd25519Pem(actor Actor) error {
+	publickey, privatekey, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		return util.MakeError(err, "CreateEd25519Pem")
+	}
+
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privatekey)
+	if err != nil {
+		return util.MakeError(err, "CreateEd25519Pem")
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publickey)
+	if err != nil {
+		return util.MakeError(err, "CreateEd25519Pem")
+	}
+
+	file := "./pem/board/" + actor.PreferredUsername + "-ed25519-public.pem"
+
+	if err := os.WriteFile(strings.ReplaceAll(file, "public.pem", "private.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes}), 0600); err != nil {
+		return util.MakeError(err, "CreateEd25519Pem")
+	}
+
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0644); err != nil {
+		return util.MakeError(err, "CreateEd25519Pem")
+	}
+
+	query := "insert into publicKeyPem (id, owner, file, type) values($1, $2, $3, $4)"
+	_, err = config.DB.Exec(query, actor.Id+"#ed25519-key", actor.Id, file, KeyTypeEd25519)
+
+	return util.MakeError(err, "CreateEd25519Pem")
+}
+
+// CreateMissingEd25519Pems gives boards created before Ed25519 support their
+// key.
+func CreateMissingEd25519Pems() error {
+	query := `select id, preferredusername from actor where id not in (select owner from publicKeyPem where type=$1)`
+	rows, err := config.DB.Query(query, KeyTypeEd25519)
+	if err != nil {
+		return util.MakeError(err, "CreateMissingEd25519Pems")
+	}
+
+	var actors []Actor
+
+	for rows.Next() {
+		var actor Actor
+		rows.Scan(&actor.Id, &actor.PreferredUsername)
+		actors = append(actors, actor)
+	}
+
+	rows.Close()
+
+	for _, e := range actors {
+		if err := CreateEd25519Pem(e); err != nil {
+			return util.MakeError(err, "CreateMissingEd25519Pems")
+		}
+	}
+
+	return nil
+}
+
+// GetPrivateKey loads the private half of one of the actors keys, the file
+// sits next to the public key stored for keyId.
+func GetPrivateKey(keyId string) (crypto.Signer, error) {
+	file, err := GetActorPemFileFromDB(keyId)
+	if err != nil || file == "" {
+		return nil, util.MakeError(errors.New("no key stored for "+keyId), "GetPrivateKey")
+	}
+
+	file = strings.ReplaceAll(file, "public.pem", "private.pem")
+
+	priv, err := os.ReadFile(file)
+	if err != nil {
+		return nil, util.MakeError(err, "GetPrivateKey")
+	}
+
+	block, _ := pem.Decode(priv)
+	if block == nil {
+		return nil, util.MakeError(errors.New("failed to decode PEM block containing private key"), "GetPrivateKey")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return key, util.MakeError(err, "GetPrivateKey")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, util.MakeError(err, "GetPrivateKey")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, util.MakeError(errors.New("unsupported private key type"), "GetPrivateKey")
+	}
+
+	return signer, nil
+}
+
+func ParsePublicKeyPem(publicKeyPem string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPem))
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing public key")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
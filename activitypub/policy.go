@@ -0,0 +1,338 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+type PolicyVerdict int
+
+const (
+	PolicyAccept PolicyVerdict = iota
+	PolicyReject
+	PolicyHold
+)
+
+// A Policy looks at an inbound Create, or a post a group announced, before
+// it reaches the boards it is addressed to. It may change the object, drop
+// the activity or hold it for review.
+type Policy interface {
+	Apply(activity *Activity) (PolicyVerdict, error)
+}
+
+// PolicyTypes builds a policy from the value stored with a federationpolicy
+// row, new policies only need an entry here.
+var PolicyTypes = map[string]func(value string) (Policy, error){
+	"stripmedia": func(string) (Policy, error) { return StripMediaPolicy{}, nil },
+	"sensitive":  func(string) (Policy, error) { return SensitivePolicy{}, nil },
+	"blacklist":  NewBlacklistPolicy,
+	"maxsize":    NewMaxSizePolicy,
+	"hold":       func(string) (Policy, error) { return HoldPolicy{}, nil },
+}
+
+type PolicyRule struct {
+	Id      int
+	Scope   string
+	Policy  string
+	Value   string
+	Created time.Time
+}
+
+type HeldActivity struct {
+	Id      int
+	Actor   string
+	Policy  string
+	Payload string
+	Created time.Time
+}
+
+type StripMediaPolicy struct{}
+
+func (StripMediaPolicy) Apply(activity *Activity) (PolicyVerdict, error) {
+	activity.Object.Attachment = nil
+	activity.Object.Preview = nil
+
+	return PolicyAccept, nil
+}
+
+type SensitivePolicy struct{}
+
+func (SensitivePolicy) Apply(activity *Activity) (PolicyVerdict, error) {
+	activity.Object.Sensitive = true
+
+	return PolicyAccept, nil
+}
+
+// BlacklistPolicy drops posts matching its regex, or the post blacklist when
+// it has none.
+type BlacklistPolicy struct {
+	Regex *regexp.Regexp
+}
+
+func NewBlacklistPolicy(value string) (Policy, error) {
+	if value == "" {
+		return BlacklistPolicy{}, nil
+	}
+
+	re, err := regexp.Compile(value)
+
+	return BlacklistPolicy{Regex: re}, err
+}
+
+func (policy BlacklistPolicy) Apply(activity *Activity) (PolicyVerdict, error) {
+	text := activity.Object.Name + "\n" + activity.Object.Content
+
+	if policy.Regex != nil {
+		if policy.Regex.MatchString(text) {
+			return PolicyReject, nil
+		}
+
+		return PolicyAccept, nil
+	}
+
+	if is, err, _ := util.IsPostBlacklist(text); err != nil {
+		return PolicyAccept, err
+	} else if is {
+		return PolicyReject, nil
+	}
+
+	return PolicyAccept, nil
+}
+
+// MaxSizePolicy drops posts whose text and attachments come to more than
+// Size bytes.
+type MaxSizePolicy struct {
+	Size int64
+}
+
+func NewMaxSizePolicy(value string) (Policy, error) {
+	size, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || size < 1 {
+		return nil, errors.New("maxsize needs a size in bytes")
+	}
+
+	return MaxSizePolicy{Size: size}, nil
+}
+
+func (policy MaxSizePolicy) Apply(activity *Activity) (PolicyVerdict, error) {
+	size := int64(len(activity.Object.Name) + len(activity.Object.Content))
+
+	for _, e := range activity.Object.Attachment {
+		size += e.Size
+	}
+
+	if size > policy.Size {
+		return PolicyReject, nil
+	}
+
+	return PolicyAccept, nil
+}
+
+type HoldPolicy struct{}
+
+func (HoldPolicy) Apply(activity *Activity) (PolicyVerdict, error) {
+	return PolicyHold, nil
+}
+
+// Matches reports whether the rule covers an actor, scopes with a scheme
+// name one remote board and bare domains a whole instance.
+func (rule PolicyRule) Matches(actor string) bool {
+	if strings.Contains(rule.Scope, "://") {
+		return rule.Scope == actor
+	}
+
+	return util.MatchInstance(util.GetInstanceHost(actor), []string{rule.Scope})
+}
+
+func GetPolicyRules() ([]PolicyRule, error) {
+	var rules []PolicyRule
+
+	query := `select id, scope, policy, value, created from federationpolicy order by id`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return rules, util.MakeError(err, "GetPolicyRules")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var rule PolicyRule
+
+		if err := rows.Scan(&rule.Id, &rule.Scope, &rule.Policy, &rule.Value, &rule.Created); err != nil {
+			return rules, util.MakeError(err, "GetPolicyRules")
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func WritePolicyRule(scope string, policy string, value string) error {
+	build, ok := PolicyTypes[policy]
+
+	if !ok {
+		return fmt.Errorf("unknown policy %s", policy)
+	}
+
+	if _, err := build(value); err != nil {
+		return err
+	}
+
+	scope = strings.TrimSuffix(strings.TrimSpace(scope), "/")
+
+	if !strings.Contains(scope, "://") {
+		scope = util.GetInstanceHost(scope)
+	}
+
+	if scope == "" {
+		return errors.New("policy needs an instance or board")
+	}
+
+	query := `insert into federationpolicy (scope, policy, value) values ($1, $2, $3)`
+	_, err := config.DB.Exec(query, scope, policy, value)
+
+	return util.MakeError(err, "WritePolicyRule")
+}
+
+func DeletePolicyRule(id int) error {
+	query := `delete from federationpolicy where id=$1`
+	_, err := config.DB.Exec(query, id)
+
+	return util.MakeError(err, "DeletePolicyRule")
+}
+
+// ApplyPolicies runs the rules matching the sender over a Create or Announce
+// in the order they were added. It returns false when a rule rejected the
+// activity or held it for review, release skips the hold rules for held
+// activities an admin let through.
+func (activity Activity) ApplyPolicies(release bool) (Activity, bool, error) {
+	if activity.Actor == nil {
+		return activity, true, nil
+	}
+
+	rules, err := GetPolicyRules()
+
+	if err != nil {
+		return activity, false, util.MakeError(err, "ApplyPolicies")
+	}
+
+	payload, err := json.Marshal(activity)
+
+	if err != nil {
+		return activity, false, util.MakeError(err, "ApplyPolicies")
+	}
+
+	for _, rule := range rules {
+		if !rule.Matches(activity.Actor.Id) || (release && rule.Policy == "hold") {
+			continue
+		}
+
+		build, ok := PolicyTypes[rule.Policy]
+
+		if !ok {
+			continue
+		}
+
+		policy, err := build(rule.Value)
+
+		if err != nil {
+			config.Log.Println(util.MakeError(err, "ApplyPolicies"))
+			continue
+		}
+
+		verdict, err := policy.Apply(&activity)
+
+		if err != nil {
+			return activity, false, util.MakeError(err, "ApplyPolicies")
+		}
+
+		switch verdict {
+		case PolicyReject:
+			config.Log.Println("Policy " + rule.Policy + " for " + rule.Scope + " rejected " + activity.Object.Id)
+			return activity, false, nil
+		case PolicyHold:
+			query := `insert into heldactivity (actor, policy, payload) values ($1, $2, $3)`
+			_, err := config.DB.Exec(query, activity.Actor.Id, rule.Policy, string(payload))
+			return activity, false, util.MakeError(err, "ApplyPolicies")
+		}
+	}
+
+	return activity, true, nil
+}
+
+func GetHeldActivities() ([]HeldActivity, error) {
+	var held []HeldActivity
+
+	query := `select id, actor, policy, payload, created from heldactivity order by created`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return held, util.MakeError(err, "GetHeldActivities")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var e HeldActivity
+
+		if err := rows.Scan(&e.Id, &e.Actor, &e.Policy, &e.Payload, &e.Created); err != nil {
+			return held, util.MakeError(err, "GetHeldActivities")
+		}
+
+		held = append(held, e)
+	}
+
+	return held, nil
+}
+
+// ReleaseHeldActivity runs a held Create or Announce through the remaining
+// policies and on to its boards. It stays held if processing fails.
+func ReleaseHeldActivity(id int) error {
+	var payload string
+
+	query := `select payload from heldactivity where id=$1`
+	if err := config.DB.QueryRow(query, id).Scan(&payload); err != nil {
+		return util.MakeError(err, "ReleaseHeldActivity")
+	}
+
+	var activity Activity
+
+	if err := json.Unmarshal([]byte(payload), &activity); err != nil {
+		return util.MakeError(err, "ReleaseHeldActivity")
+	}
+
+	activity, ok, err := activity.ApplyPolicies(true)
+
+	if err != nil {
+		return util.MakeError(err, "ReleaseHeldActivity")
+	}
+
+	if ok {
+		process := activity.ProcessCreate
+		if activity.Type == "Announce" {
+			process = activity.ProcessAnnounced
+		}
+
+		if err := process(); err != nil {
+			return util.MakeError(err, "ReleaseHeldActivity")
+		}
+	}
+
+	return util.MakeError(DeleteHeldActivity(id), "ReleaseHeldActivity")
+}
+
+func DeleteHeldActivity(id int) error {
+	query := `delete from heldactivity where id=$1`
+	_, err := config.DB.Exec(query, id)
+
+	return util.MakeError(err, "DeleteHeldActivity")
+}
@@ -0,0 +1,341 @@
+package activitypub
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+)
+
+const (
+	RelayPending  = "pending"
+	RelayAccepted = "accepted"
+	RelayRejected = "rejected"
+)
+
+// A Relay is subscribed to by the instance actor. Url is what the admin
+// added, the inbox of a Mastodon style relay or the actor of a LitePub one.
+// Actor is learnt from the Accept when only the inbox was given.
+type Relay struct {
+	Id      int
+	Url     string
+	Actor   string
+	Inbox   string
+	Follow  string
+	Status  string
+	Created time.Time
+}
+
+// A RelayBoard is a remote board found in a post a relay announced.
+type RelayBoard struct {
+	Id                string
+	Relay             string
+	Name              string
+	PreferredUsername string
+	Summary           string
+	LastSeen          time.Time
+}
+
+// RelayActivity is a Follow or Undo the instance actor sends a relay.
+type RelayActivity struct {
+	Context string      `json:"@context"`
+	Id      string      `json:"id"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	Object  interface{} `json:"object"`
+	To      []string    `json:"to,omitempty"`
+}
+
+// SubscribeRelay follows a relay as the instance actor. Mastodon style
+// relays are added by their inbox and followed through the public
+// collection, anything else is taken to be a LitePub relay actor.
+func SubscribeRelay(url string) error {
+	url = strings.TrimSuffix(strings.TrimSpace(url), "/")
+
+	if url == "" {
+		return errors.New("no relay given")
+	}
+
+	if !util.IsInstanceAllowed(url) {
+		return errors.New("instance is not federated with")
+	}
+
+	relay := Relay{Url: url, Inbox: url}
+	var object interface{} = publicAddress
+
+	if !strings.HasSuffix(url, "/inbox") {
+		actor, err := RefreshActor(url)
+
+		if err != nil || actor.Inbox == "" {
+			return errors.New("no relay actor found at " + url)
+		}
+
+		relay.Actor = actor.Id
+		relay.Inbox = actor.Inbox
+		object = actor.Id
+	}
+
+	relay.Follow = config.Domain + "#relays/follows/" + util.RandomID(8)
+
+	query := `insert into relay (url, actor, inbox, follow) values ($1, $2, $3, $4)`
+	if _, err := config.DB.Exec(query, relay.Url, relay.Actor, relay.Inbox, relay.Follow); err != nil {
+		return util.MakeError(err, "SubscribeRelay")
+	}
+
+	follow := RelayActivity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      relay.Follow,
+		Type:    "Follow",
+		Actor:   config.Domain,
+		Object:  object,
+	}
+
+	payload, err := json.Marshal(follow)
+
+	if err != nil {
+		return util.MakeError(err, "SubscribeRelay")
+	}
+
+	return util.MakeError(EnqueueDelivery(config.Domain, relay.Inbox, payload), "SubscribeRelay")
+}
+
+// UnsubscribeRelay undoes the Follow and forgets the relay.
+func UnsubscribeRelay(id int) error {
+	var relay Relay
+
+	query := `delete from relay where id=$1 returning url, actor, inbox, follow`
+	err := config.DB.QueryRow(query, id).Scan(&relay.Url, &relay.Actor, &relay.Inbox, &relay.Follow)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return util.MakeError(err, "UnsubscribeRelay")
+	}
+
+	object := relay.Actor
+	if object == "" || strings.HasSuffix(relay.Url, "/inbox") {
+		object = publicAddress
+	}
+
+	undo := RelayActivity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      config.Domain + "#relays/undos/" + util.RandomID(8),
+		Type:    "Undo",
+		Actor:   config.Domain,
+		Object: ActivityRef{
+			Id:     relay.Follow,
+			Type:   "Follow",
+			Actor:  config.Domain,
+			Object: object,
+		},
+	}
+
+	payload, err := json.Marshal(undo)
+
+	if err != nil {
+		return util.MakeError(err, "UnsubscribeRelay")
+	}
+
+	return util.MakeError(EnqueueDelivery(config.Domain, relay.Inbox, payload), "UnsubscribeRelay")
+}
+
+func GetRelays() ([]Relay, error) {
+	var relays []Relay
+
+	query := `select id, url, actor, inbox, follow, status, created from relay order by id`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return relays, util.MakeError(err, "GetRelays")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var relay Relay
+
+		if err := rows.Scan(&relay.Id, &relay.Url, &relay.Actor, &relay.Inbox, &relay.Follow, &relay.Status, &relay.Created); err != nil {
+			return relays, util.MakeError(err, "GetRelays")
+		}
+
+		relays = append(relays, relay)
+	}
+
+	return relays, nil
+}
+
+// IsRelay reports whether the actor is a relay the instance subscribes to.
+func (actor Actor) IsRelay() (bool, error) {
+	var count int
+
+	query := `select count(*) from relay where actor=$1 and status=$2`
+	if err := config.DB.QueryRow(query, actor.Id, RelayAccepted).Scan(&count); err != nil {
+		return false, util.MakeError(err, "IsRelay")
+	}
+
+	return count > 0, nil
+}
+
+// ProcessRelayResponse records a relay's answer to our Follow. Relays that
+// do not echo the Follow's id are matched by the host of their inbox, as long
+// as it answers a Follow of the instance actor rather than one of a board's.
+// It returns false when the activity was not for a relay.
+func (activity Activity) ProcessRelayResponse() (bool, error) {
+	relays, err := GetRelays()
+
+	if err != nil {
+		return false, util.MakeError(err, "ProcessRelayResponse")
+	}
+
+	host := util.GetInstanceHost(activity.Actor.Id)
+
+	for _, relay := range relays {
+		if util.GetInstanceHost(relay.Inbox) != host {
+			continue
+		}
+
+		if activity.Object.Id != relay.Follow && (relay.Status != RelayPending || (activity.Object.Actor != "" && activity.Object.Actor != config.Domain)) {
+			continue
+		}
+
+		status := RelayAccepted
+		if activity.Type == "Reject" {
+			status = RelayRejected
+		}
+
+		query := `update relay set status=$1, actor=$2 where id=$3`
+		if _, err := config.DB.Exec(query, status, activity.Actor.Id, relay.Id); err != nil {
+			return true, util.MakeError(err, "ProcessRelayResponse")
+		}
+
+		config.Log.Println("relay " + relay.Url + " " + status + " our follow")
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// ProcessRelayAnnounce looks at the post a relay announced for the board it
+// is on, which is listed for admins to subscribe to. Relayed posts are not
+// shown on any board.
+func (activity Activity) ProcessRelayAnnounce() error {
+	id := activity.Object.Id
+
+	if activity.Object.Type == "Create" && activity.Object.Object != nil {
+		id = activity.Object.Object.Id
+	}
+
+	if id == "" || !util.IsInstanceAllowed(id) {
+		return nil
+	}
+
+	col, err := Activity{Id: id}.GetCollection()
+
+	if err != nil {
+		return util.MakeError(err, "ProcessRelayAnnounce")
+	}
+
+	if len(col.OrderedItems) == 0 || col.OrderedItems[0].Actor == "" {
+		return nil
+	}
+
+	return util.MakeError(DiscoverBoard(col.OrderedItems[0].Actor, activity.Actor.Id), "ProcessRelayAnnounce")
+}
+
+// DiscoverBoard lists a remote board found through a relay.
+func DiscoverBoard(id string, relay string) error {
+	if local, _ := (Actor{Id: id}).IsLocal(); local || !util.IsInstanceAllowed(id) {
+		return nil
+	}
+
+	board, err := GetActor(id)
+
+	if err != nil || board.Id != id || !board.IsBoard() || board.Outbox == "" {
+		return util.MakeError(err, "DiscoverBoard")
+	}
+
+	name := []rune(board.Name)
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	summary := []rune(board.Summary)
+	if len(summary) > 200 {
+		summary = summary[:200]
+	}
+
+	query := `insert into relayboard (id, relay, name, preferredusername, summary) values ($1, $2, $3, $4, $5) on conflict (id) do update set relay=$2, name=$3, preferredusername=$4, summary=$5, lastseen=NOW()`
+	_, err = config.DB.Exec(query, board.Id, relay, string(name), board.PreferredUsername, string(summary))
+
+	return util.MakeError(err, "DiscoverBoard")
+}
+
+// GetRelayBoards returns the boards relays brought up that no local board
+// follows yet, most recently seen first.
+func GetRelayBoards(limit int) ([]RelayBoard, error) {
+	var boards []RelayBoard
+
+	query := `select id, relay, name, preferredusername, summary, lastseen from relayboard where id not in (select following from following) order by lastseen desc limit $1`
+	rows, err := config.DB.Query(query, limit)
+
+	if err != nil {
+		return boards, util.MakeError(err, "GetRelayBoards")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var board RelayBoard
+
+		if err := rows.Scan(&board.Id, &board.Relay, &board.Name, &board.PreferredUsername, &board.Summary, &board.LastSeen); err != nil {
+			return boards, util.MakeError(err, "GetRelayBoards")
+		}
+
+		boards = append(boards, board)
+	}
+
+	return boards, nil
+}
+
+// PublishToRelays sends a new thread on a board that publishes to relays to
+// every relay that accepted the instance.
+func (actor Actor) PublishToRelays(activity Activity) error {
+	if !actor.HasOption(OptionRelay) || actor.HasOption(OptionReadOnly) {
+		return nil
+	}
+
+	if activity.Type != "Create" || (len(activity.Object.InReplyTo) > 0 && activity.Object.InReplyTo[0].Id != "") {
+		return nil
+	}
+
+	relays, err := GetRelays()
+
+	if err != nil {
+		return util.MakeError(err, "PublishToRelays")
+	}
+
+	// Relays only pass on public posts
+	activity.To = []string{publicAddress}
+	activity.Cc = []string{actor.Id + "/followers"}
+
+	payload, err := json.Marshal(activity)
+
+	if err != nil {
+		return util.MakeError(err, "PublishToRelays")
+	}
+
+	for _, relay := range relays {
+		if relay.Status != RelayAccepted {
+			continue
+		}
+
+		if err := EnqueueDelivery(actor.Id, relay.Inbox, payload); err != nil {
+			return util.MakeError(err, "PublishToRelays")
+		}
+	}
+
+	return nil
+}
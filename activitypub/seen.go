@@ -0,0 +1,120 @@
+package activitypub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SeenKey identifies an activity for de-duplication. FChannel does not give
+// its activities ids, for those the object is used where a repeat can only
+// be a duplicate. The key is the sender's, so an activity someone else sends
+// with the same id cannot mark the real one as seen.
+func (activity Activity) SeenKey() string {
+	if activity.Actor == nil || activity.Actor.Id == "" {
+		return ""
+	}
+
+	if activity.Id != "" {
+		return activity.Actor.Id + " " + activity.Id
+	}
+
+	if activity.Object.Id == "" {
+		return ""
+	}
+
+	switch activity.Type {
+	case "Create", "Delete":
+		return activity.Actor.Id + " " + activity.Type + " " + activity.Object.Id
+	case "Update":
+		if activity.Object.Updated != nil {
+			return activity.Actor.Id + " " + activity.Type + " " + activity.Object.Id + " " + activity.Object.Updated.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	return ""
+}
+
+// seenHash is what is stored for a key, which with the sender's id can be
+// longer than the column.
+func seenHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// MarkSeen records the activity and reports whether it had been seen before.
+func (activity Activity) MarkSeen() (bool, error) {
+	key := activity.SeenKey()
+
+	if key == "" {
+		return false, nil
+	}
+
+	query := `insert into seenactivity (id) values ($1) on conflict (id) do nothing`
+	res, err := config.DB.Exec(query, seenHash(key))
+
+	if err != nil {
+		return false, util.MakeError(err, "MarkSeen")
+	}
+
+	n, _ := res.RowsAffected()
+
+	return n == 0, nil
+}
+
+// UnmarkSeen forgets an activity that failed to process so a retry is not
+// taken for a duplicate.
+func (activity Activity) UnmarkSeen() error {
+	key := activity.SeenKey()
+
+	if key == "" {
+		return nil
+	}
+
+	query := `delete from seenactivity where id=$1`
+	_, err := config.DB.Exec(query, seenHash(key))
+
+	return util.MakeError(err, "UnmarkSeen")
+}
+
+// IsReplayedSignature records the signature of a verified request and reports
+// whether the same signature was already used, which within the time a
+// signature is valid can only be a replay.
+func IsReplayedSignature(ctx *fiber.Ctx) (bool, error) {
+	query := `insert into seensignature (hash) values ($1) on conflict (hash) do nothing`
+	res, err := config.DB.Exec(query, seenHash(ctx.Get("Signature")))
+
+	if err != nil {
+		return false, util.MakeError(err, "IsReplayedSignature")
+	}
+
+	n, _ := res.RowsAffected()
+
+	return n == 0, nil
+}
+
+func StartPruneSeen() {
+	for {
+		if err := PruneSeen(); err != nil {
+			config.Log.Println(err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
+
+func PruneSeen() error {
+	query := `delete from seenactivity where created < NOW() - make_interval(secs => $1)`
+	if _, err := config.DB.Exec(query, float64(config.SeenRetention)); err != nil {
+		return util.MakeError(err, "PruneSeen")
+	}
+
+	query = `delete from seensignature where created < NOW() - make_interval(secs => $1)`
+	_, err := config.DB.Exec(query, (2 * signatureMaxAge).Seconds())
+
+	return util.MakeError(err, "PruneSeen")
+}
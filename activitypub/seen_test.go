@@ -0,0 +1,36 @@
+package activitypub
+
+import "testing"
+
+func TestSeenKeyBySender(t *testing.T) {
+	owner := &Actor{Id: "https://a.example/users/alice"}
+	other := &Actor{Id: "https://a.example/users/mallory"}
+
+	tests := []struct {
+		name     string
+		activity Activity
+	}{
+		{name: "activity id", activity: Activity{Id: "https://a.example/activities/1", Type: "Delete"}},
+		{name: "create", activity: Activity{Type: "Create", Object: ObjectBase{Id: "https://a.example/notes/1"}}},
+		{name: "delete", activity: Activity{Type: "Delete", Object: ObjectBase{Id: "https://a.example/notes/1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.activity, tt.activity
+			a.Actor, b.Actor = owner, other
+
+			if a.SeenKey() == "" {
+				t.Fatal("SeenKey() is empty")
+			}
+
+			if a.SeenKey() == b.SeenKey() {
+				t.Errorf("SeenKey() = %q for both senders", a.SeenKey())
+			}
+		})
+	}
+
+	if key := (Activity{Id: "https://a.example/activities/1"}).SeenKey(); key != "" {
+		t.Errorf("SeenKey() without an actor = %q, want none", key)
+	}
+}
@@ -0,0 +1,657 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Signature schemes negotiated per instance. Every peer starts on the cavage
+// draft and is moved to RFC 9421 once it signs a request to us that way.
+const (
+	SchemeCavage  = "cavage"
+	SchemeRFC9421 = "rfc9421"
+)
+
+const (
+	AlgorithmRSASHA256 = "rsa-v1_5-sha256"
+	AlgorithmRSAPSS    = "rsa-pss-sha512"
+	AlgorithmEd25519   = "ed25519"
+)
+
+// Accepted clock skew for signed requests.
+const signatureMaxAge = 75 * time.Second
+
+// How old a cached actor has to be before an unknown keyId refetches it.
+const keyRefetchInterval = 5 * time.Minute
+
+type MessageSignature struct {
+	Label      string
+	Components []string
+	Params     map[string]string
+	Raw        string
+	Signature  []byte
+}
+
+// SignRequest adds the headers for an outgoing request signed with the actors
+// key, using whichever scheme was negotiated with the receiving instance. body
+// must be the exact request body.
+func (actor Actor) SignRequest(req *http.Request, body []byte) error {
+	if actor.PublicKey == nil || actor.PublicKey.Id == "" {
+		var err error
+		if actor, err = GetActorFromDB(actor.Id); err != nil {
+			return util.MakeError(err, "SignRequest")
+		}
+	}
+
+	scheme, algorithm := GetSignatureScheme(req.URL.Host)
+
+	if scheme == SchemeRFC9421 {
+		return actor.SignRequestRFC9421(req, body, algorithm)
+	}
+
+	date := time.Now().UTC().Format(time.RFC1123)
+	host := req.URL.Host
+	path := req.URL.RequestURI()
+
+	headers := "(request-target) host date"
+	sig := fmt.Sprintf("(request-target): %s %s\nhost: %s\ndate: %s", strings.ToLower(req.Method), path, host, date)
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		digest := MakeDigest(body)
+		headers += " digest"
+		sig += "\ndigest: " + digest
+		req.Header.Set("Digest", digest)
+	}
+
+	encSig, err := actor.ActivitySign(sig)
+
+	if err != nil {
+		return util.MakeError(err, "SignRequest")
+	}
+
+	signature := fmt.Sprintf("keyId=\"%s\",headers=\"%s\",signature=\"%s\"", actor.PublicKey.Id, headers, encSig)
+
+	req.Header.Set("Date", date)
+	req.Header.Set("Signature", signature)
+	req.Host = host
+
+	return nil
+}
+
+// SignFetch signs an outgoing GET as the instance actor so peers running
+// authorized fetch answer it. The request is still sent when signing fails.
+func SignFetch(req *http.Request) {
+	actor, err := GetActorFromDB(config.Domain)
+
+	if err == nil {
+		err = actor.SignRequest(req, nil)
+	}
+
+	if err != nil {
+		config.Log.Println(util.MakeError(err, "SignFetch"))
+	}
+}
+
+// SignRequestRFC9421 signs the request with Signature-Input and Signature
+// headers. The Ed25519 key is only used when the peer signed with one itself.
+func (actor Actor) SignRequestRFC9421(req *http.Request, body []byte, algorithm string) error {
+	keyId := actor.PublicKey.Id
+
+	if algorithm == AlgorithmEd25519 {
+		if keys, _ := actor.GetMultikeys(); len(keys) > 0 {
+			keyId = keys[0].Id
+		}
+	}
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Date", date)
+	req.Host = req.URL.Host
+
+	components := []string{"@method", "@target-uri", "date"}
+	values := []string{req.Method, req.URL.String(), date}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		digest := MakeContentDigest(body)
+		req.Header.Set("Content-Digest", digest)
+		components = append(components, "content-digest")
+		values = append(values, digest)
+	}
+
+	key, err := GetPrivateKey(keyId)
+
+	if err != nil {
+		return util.MakeError(err, "SignRequestRFC9421")
+	}
+
+	var base strings.Builder
+
+	for i, e := range components {
+		base.WriteString(`"` + e + `": ` + values[i] + "\n")
+	}
+
+	params := "(\"" + strings.Join(components, "\" \"") + "\");created=" + strconv.FormatInt(time.Now().Unix(), 10) + ";keyid=\"" + keyId + "\";alg=\"" + KeyAlgorithm(key) + "\""
+	base.WriteString(`"@signature-params": ` + params)
+
+	sig, _, err := SignData(key, []byte(base.String()))
+
+	if err != nil {
+		return util.MakeError(err, "SignRequestRFC9421")
+	}
+
+	req.Header.Set("Signature-Input", "sig1="+params)
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+
+	return nil
+}
+
+// VerifyMessageSignature checks RFC 9421 signatures on an inbound request.
+// One valid signature by one of the actors keys is enough.
+func (actor Actor) VerifyMessageSignature(ctx *fiber.Ctx) error {
+	inputs, err := ParseSignatureInput(ctx.Get("Signature-Input"))
+
+	if err != nil {
+		return util.MakeError(err, "VerifyMessageSignature")
+	}
+
+	signatures, err := parseByteSequences(ctx.Get("Signature"))
+
+	if err != nil {
+		return util.MakeError(err, "VerifyMessageSignature")
+	}
+
+	for _, input := range inputs {
+		sig, ok := signatures[input.Label]
+		if !ok {
+			continue
+		}
+
+		input.Signature = sig
+
+		if err := actor.verifyMessageSignature(ctx, input); err != nil {
+			config.Log.Printf("signature %s from %s rejected: %v", input.Label, actor.Id, err)
+			continue
+		}
+
+		if u, err := url.Parse(input.Params["keyid"]); err == nil && u.Host != "" {
+			if err := SetSignatureScheme(u.Host, SchemeRFC9421, input.Params["alg"]); err != nil {
+				config.Log.Println(err)
+			}
+		}
+
+		return nil
+	}
+
+	return errors.New("no valid signature for " + actor.Id)
+}
+
+// GetSignatureKeyIds returns the key ids a request claims to be signed with,
+// without checking the signatures.
+func GetSignatureKeyIds(ctx *fiber.Ctx) []string {
+	var ids []string
+
+	if input := ctx.Get("Signature-Input"); input != "" {
+		inputs, _ := ParseSignatureInput(input)
+
+		for _, e := range inputs {
+			ids = append(ids, e.Params["keyid"])
+		}
+	} else if sig := ctx.Get("Signature"); sig != "" {
+		ids = append(ids, ParseHeaderSignature(sig).KeyId)
+	}
+
+	return ids
+}
+
+func (actor Actor) verifyMessageSignature(ctx *fiber.Ctx, input MessageSignature) error {
+	key, err := actor.GetVerificationKey(input.Params["keyid"])
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	if created, ok := input.Params["created"]; ok {
+		ts, err := strconv.ParseInt(created, 10, 64)
+		if err != nil {
+			return errors.New("invalid created parameter")
+		}
+
+		if d := now.Sub(time.Unix(ts, 0)); d > signatureMaxAge || d < -signatureMaxAge {
+			return errors.New("signature is too old")
+		}
+	} else if !util.IsInStringArray(input.Components, "date") {
+		return errors.New("signature has no creation time")
+	}
+
+	if expires, ok := input.Params["expires"]; ok {
+		if ts, err := strconv.ParseInt(expires, 10, 64); err != nil || now.Unix() > ts {
+			return errors.New("signature expired")
+		}
+	}
+
+	if util.IsInStringArray(input.Components, "date") {
+		t, err := http.ParseTime(ctx.Get("Date"))
+		if err != nil || now.Sub(t) > signatureMaxAge {
+			return errors.New("date is too old")
+		}
+	}
+
+	if !util.IsInStringArray(input.Components, "@method") {
+		return errors.New("@method is not covered")
+	}
+
+	if !util.IsInStringArray(input.Components, "@target-uri") && !util.IsInStringArray(input.Components, "@path") && !util.IsInStringArray(input.Components, "@request-target") {
+		return errors.New("request target is not covered")
+	}
+
+	if ctx.Method() != "GET" && ctx.Method() != "HEAD" {
+		if !util.IsInStringArray(input.Components, "content-digest") || !VerifyContentDigest(ctx.Get("Content-Digest"), ctx.Body()) {
+			return errors.New("content digest does not match body")
+		}
+	}
+
+	base, err := messageSignatureBase(ctx, input)
+
+	if err != nil {
+		return err
+	}
+
+	return VerifySignature(key, input.Params["alg"], input.Signature, []byte(base))
+}
+
+func messageSignatureBase(ctx *fiber.Ctx, input MessageSignature) (string, error) {
+	scheme := strings.TrimSuffix(config.TP, "://")
+	if scheme == "" {
+		scheme = ctx.Protocol()
+	}
+
+	target := string(ctx.Request().URI().RequestURI())
+	path, query, _ := strings.Cut(target, "?")
+
+	var base strings.Builder
+
+	for _, e := range input.Components {
+		var value string
+
+		switch e {
+		case "@method":
+			value = ctx.Method()
+		case "@target-uri":
+			value = scheme + "://" + ctx.Hostname() + target
+		case "@authority":
+			value = strings.ToLower(ctx.Hostname())
+		case "@scheme":
+			value = scheme
+		case "@request-target":
+			value = target
+		case "@path":
+			value = path
+		case "@query":
+			value = "?" + query
+		default:
+			if strings.HasPrefix(e, "@") {
+				return "", errors.New("unsupported component " + e)
+			}
+
+			if ctx.Request().Header.Peek(e) == nil {
+				return "", errors.New("missing covered header " + e)
+			}
+
+			value = strings.TrimSpace(ctx.Get(e))
+		}
+
+		base.WriteString(`"` + e + `": ` + value + "\n")
+	}
+
+	base.WriteString(`"@signature-params": ` + input.Raw)
+
+	return base.String(), nil
+}
+
+// GetVerificationKey finds the public key an actor published under keyId,
+// either its main publicKey or a Multikey listed in assertionMethod. Keys
+// still in their rotation grace period are accepted too. The key is always
+// looked up in the actor document we stored or fetched ourselves, an actor
+// embedded in the request body is never trusted for it.
+func (actor Actor) GetVerificationKey(keyId string) (crypto.PublicKey, error) {
+	if !IsKeyOwner(keyId, actor.Id) {
+		return nil, errors.New("key " + keyId + " does not belong to " + actor.Id)
+	}
+
+	published, err := GetPublishedActor(actor.Id)
+
+	if err == nil {
+		var key crypto.PublicKey
+
+		if key, err = published.getPublishedKey(keyId); err == nil {
+			return key, nil
+		}
+	}
+
+	if key, err := GetGraceKey(keyId, actor.Id); err == nil {
+		return key, nil
+	}
+
+	// An unknown key usually means the actor rotated its keys since we
+	// cached it. A copy fetched only recently is kept, or every request with
+	// a made up keyId would cost us a fetch of the actor.
+	if !strings.HasPrefix(actor.Id, config.Domain) && IsCachedActorStale(actor.Id, keyRefetchInterval) {
+		if nActor, rerr := RefreshActor(actor.Id); rerr == nil && nActor.Id == actor.Id {
+			return nActor.getPublishedKey(keyId)
+		}
+	}
+
+	return nil, err
+}
+
+// GetPublishedActor returns the actor document for id as published by its
+// instance: local boards come from the database, remote actors from the cache
+// or a fetch of id.
+func GetPublishedActor(id string) (Actor, error) {
+	if strings.HasPrefix(id, config.Domain) {
+		actor, err := GetActorFromDB(id)
+		return actor, util.MakeError(err, "GetPublishedActor")
+	}
+
+	actor, err := GetActor(id)
+
+	if err != nil {
+		return actor, util.MakeError(err, "GetPublishedActor")
+	}
+
+	if actor.Id != id {
+		return actor, util.MakeError(errors.New("fetched actor "+actor.Id+" is not "+id), "GetPublishedActor")
+	}
+
+	return actor, nil
+}
+
+// IsKeyOwner reports whether keyId is published by the actor id, either as a
+// fragment of the actor document or as a document below it.
+func IsKeyOwner(keyId string, id string) bool {
+	if id == "" {
+		return false
+	}
+
+	owner, _, _ := strings.Cut(keyId, "#")
+
+	return owner == id || strings.HasPrefix(owner, strings.TrimSuffix(id, "/")+"/")
+}
+
+func (actor Actor) getPublishedKey(keyId string) (crypto.PublicKey, error) {
+	if actor.PublicKey != nil && actor.PublicKey.Id == keyId {
+		if actor.PublicKey.PublicKeyPem == "" {
+			return nil, errors.New("no public key for " + actor.Id)
+		}
+
+		return ParsePublicKeyPem(actor.PublicKey.PublicKeyPem)
+	}
+
+	keys := actor.AssertionMethod
+
+	if len(keys) == 0 && strings.HasPrefix(actor.Id, config.Domain) {
+		keys, _ = actor.GetMultikeys()
+	}
+
+	for _, e := range keys {
+		if e.Id == keyId && e.PublicKeyMultibase != "" {
+			return DecodeMultikey(e.PublicKeyMultibase)
+		}
+	}
+
+	return nil, errors.New("unknown key " + keyId + " for " + actor.Id)
+}
+
+// SignData signs with RSA PKCS#1 v1.5 over SHA-256 or plain Ed25519 depending
+// on the key.
+func SignData(key crypto.Signer, data []byte) ([]byte, string, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		hashed := sha256.Sum256(data)
+		sig, err := rsa.SignPKCS1v15(crand.Reader, key, crypto.SHA256, hashed[:])
+		return sig, AlgorithmRSASHA256, err
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, data), AlgorithmEd25519, nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported key type %T", key)
+}
+
+func KeyAlgorithm(key crypto.Signer) string {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return AlgorithmEd25519
+	}
+
+	return AlgorithmRSASHA256
+}
+
+// VerifySignature checks sig over data. An empty algorithm, or hs2019 from
+// the cavage draft, means the algorithm follows from the key type.
+func VerifySignature(key crypto.PublicKey, algorithm string, sig []byte, data []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case "", "hs2019", "rsa-sha256", AlgorithmRSASHA256:
+			hashed := sha256.Sum256(data)
+			return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig)
+		case AlgorithmRSAPSS:
+			hashed := sha512.Sum512(data)
+			return rsa.VerifyPSS(key, crypto.SHA512, hashed[:], sig, &rsa.PSSOptions{SaltLength: 64})
+		}
+	case ed25519.PublicKey:
+		if algorithm != "" && algorithm != "hs2019" && algorithm != AlgorithmEd25519 {
+			break
+		}
+
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("ed25519 verification failed")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key type %T or algorithm %q", key, algorithm)
+}
+
+// ParseSignatureInput parses the structured field dictionary of a
+// Signature-Input header. Component parameters such as ;sf are not
+// supported and reject the whole member.
+func ParseSignatureInput(header string) ([]MessageSignature, error) {
+	var inputs []MessageSignature
+
+	for _, member := range splitStructuredField(header, ',') {
+		label, value, ok := strings.Cut(member, "=")
+		if !ok || !strings.HasPrefix(value, "(") {
+			return nil, errors.New("malformed Signature-Input")
+		}
+
+		end := strings.Index(value, ")")
+		if end < 0 {
+			return nil, errors.New("malformed Signature-Input")
+		}
+
+		input := MessageSignature{Label: strings.TrimSpace(label), Params: make(map[string]string), Raw: value}
+
+		for _, e := range strings.Fields(value[1:end]) {
+			if len(e) < 2 || e[0] != '"' || e[len(e)-1] != '"' {
+				return nil, errors.New("unsupported component " + e)
+			}
+
+			input.Components = append(input.Components, strings.ToLower(e[1:len(e)-1]))
+		}
+
+		for _, e := range splitStructuredField(value[end+1:], ';') {
+			k, v, _ := strings.Cut(e, "=")
+			input.Params[k] = strings.Trim(v, `"`)
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+func parseByteSequences(header string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+
+	for _, member := range splitStructuredField(header, ',') {
+		label, value, ok := strings.Cut(member, "=")
+		if !ok || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, errors.New("malformed Signature")
+		}
+
+		b, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		values[strings.TrimSpace(label)] = b
+	}
+
+	return values, nil
+}
+
+// splitStructuredField splits on sep outside of quoted strings and inner
+// lists, dropping empty parts.
+func splitStructuredField(value string, sep rune) []string {
+	var parts []string
+	var quoted bool
+	var depth int
+	var start int
+
+	for i, e := range value {
+		switch {
+		case e == '"' && (i == 0 || value[i-1] != '\\'):
+			quoted = !quoted
+		case quoted:
+		case e == '(':
+			depth++
+		case e == ')':
+			depth--
+		case e == sep && depth == 0:
+			if part := strings.TrimSpace(value[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+		}
+	}
+
+	if part := strings.TrimSpace(value[start:]); part != "" {
+		parts = append(parts, part)
+	}
+
+	return parts
+}
+
+func MakeDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// VerifyDigest checks a Digest header against the body. The header may list
+// several algorithms, at least one supported one has to be present and every
+// supported one has to match.
+func VerifyDigest(header string, body []byte) bool {
+	var checked bool
+
+	for _, e := range strings.Split(header, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(e), "=")
+		if !ok {
+			continue
+		}
+
+		sum := digestSum(algo, body)
+		if sum == nil {
+			continue
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || subtle.ConstantTimeCompare(expected, sum) != 1 {
+			return false
+		}
+
+		checked = true
+	}
+
+	return checked
+}
+
+// MakeContentDigest is the RFC 9530 Content-Digest counterpart of MakeDigest.
+func MakeContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func VerifyContentDigest(header string, body []byte) bool {
+	var checked bool
+
+	for _, e := range splitStructuredField(header, ',') {
+		algo, value, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+
+		sum := digestSum(algo, body)
+		if sum == nil {
+			continue
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+		if err != nil || subtle.ConstantTimeCompare(expected, sum) != 1 {
+			return false
+		}
+
+		checked = true
+	}
+
+	return checked
+}
+
+func digestSum(algorithm string, body []byte) []byte {
+	switch strings.ToUpper(strings.TrimSpace(algorithm)) {
+	case "SHA-256":
+		s := sha256.Sum256(body)
+		return s[:]
+	case "SHA-512":
+		s := sha512.Sum512(body)
+		return s[:]
+	}
+
+	return nil
+}
+
+func GetSignatureScheme(instance string) (string, string) {
+	var scheme, algorithm string
+
+	query := `select scheme, algorithm from instancesignature where instance=$1`
+	if err := config.DB.QueryRow(query, strings.ToLower(instance)).Scan(&scheme, &algorithm); err != nil {
+		return SchemeCavage, ""
+	}
+
+	return scheme, algorithm
+}
+
+func SetSignatureScheme(instance string, scheme string, algorithm string) error {
+	query := `insert into instancesignature (instance, scheme, algorithm, updated) values ($1, $2, $3, NOW()) on conflict (instance) do update set scheme=$2, algorithm=$3, updated=NOW()`
+	_, err := config.DB.Exec(query, strings.ToLower(instance), scheme, algorithm)
+
+	return util.MakeError(err, "SetSignatureScheme")
+}
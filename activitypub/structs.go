@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"html/template"
 	"time"
+
+	"github.com/anomalous69/fchannel/util"
 )
 
 type AtContextRaw struct {
@@ -21,6 +23,8 @@ type ActivityRaw struct {
 	BtoRaw    json.RawMessage `json:"bto,omitempty"`
 	CcRaw     json.RawMessage `json:"cc,omitempty"`
 	Published time.Time       `json:"published,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	TargetRaw json.RawMessage `json:"target,omitempty"`
 	ActorRaw  json.RawMessage `json:"actor,omitempty"`
 	ObjectRaw json.RawMessage `json:"object,omitempty"`
 }
@@ -84,6 +88,33 @@ type Actor struct {
 	Restricted        bool          `json:"restricted"`
 	BoardType         string        `json:"boardtype,omitempty"`
 	OptionsMask       int           `json:"optionsmask,omitempty"`
+	AssertionMethod   []Multikey    `json:"assertionMethod,omitempty"`
+	Endpoints         *Endpoints    `json:"endpoints,omitempty"`
+	AlsoKnownAs       []string      `json:"alsoKnownAs,omitempty"`
+	MovedTo           string        `json:"movedTo,omitempty"`
+}
+
+// UnmarshalJSON for Actor accepts a single id for alsoKnownAs, which JSON-LD
+// compaction leaves when there is only one.
+func (actor *Actor) UnmarshalJSON(data []byte) error {
+	type Alias Actor // Prevent recursion
+	aux := &struct {
+		AlsoKnownAs json.RawMessage `json:"alsoKnownAs,omitempty"`
+		*Alias
+	}{
+		Alias: (*Alias)(actor),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	actor.AlsoKnownAs = stringList(aux.AlsoKnownAs)
+
+	return nil
+}
+
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
 }
 
 type PublicKeyPem struct {
@@ -92,6 +123,13 @@ type PublicKeyPem struct {
 	PublicKeyPem string `json:"publicKeyPem,omitempty"`
 }
 
+type Multikey struct {
+	Id                 string `json:"id,omitempty"`
+	Type               string `json:"type,omitempty"`
+	Controller         string `json:"controller,omitempty"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+}
+
 type Activity struct {
 	AtContext
 	Type      string     `json:"type,omitempty"`
@@ -104,6 +142,8 @@ type Activity struct {
 	Bto       []string   `json:"bto,omitempty"`
 	Cc        []string   `json:"cc,omitempty"`
 	Published time.Time  `json:"published,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	Target    string     `json:"target,omitempty"`
 	Object    ObjectBase `json:"object,omitempty"`
 }
 
@@ -149,6 +189,114 @@ type ObjectBase struct {
 	Locked       bool              `json:"locked,omitempty"`
 }
 
+// UnmarshalJSON for ObjectBase also accepts the shapes microblog software
+// sends, a bare id or a single object where we use arrays and objects where
+// we use ids.
+func (obj *ObjectBase) UnmarshalJSON(data []byte) error {
+	type Alias ObjectBase // Prevent recursion
+	aux := &struct {
+		Actor        json.RawMessage `json:"actor,omitempty"`
+		AttributedTo json.RawMessage `json:"attributedTo,omitempty"`
+		Audience     json.RawMessage `json:"audience,omitempty"`
+		Icon         json.RawMessage `json:"icon,omitempty"`
+		Image        json.RawMessage `json:"image,omitempty"`
+		InReplyTo    json.RawMessage `json:"inReplyTo,omitempty"`
+		Attachment   json.RawMessage `json:"attachment,omitempty"`
+		Tag          json.RawMessage `json:"tag,omitempty"`
+		Url          json.RawMessage `json:"url,omitempty"`
+		Replies      json.RawMessage `json:"replies,omitempty"`
+		To           json.RawMessage `json:"to,omitempty"`
+		Bto          json.RawMessage `json:"bto,omitempty"`
+		Cc           json.RawMessage `json:"cc,omitempty"`
+		Bcc          json.RawMessage `json:"Bcc,omitempty"`
+		Poster       *string         `json:"poster,omitempty"`
+		Source       *InteropSource  `json:"source,omitempty"`
+		Thread       json.RawMessage `json:"context,omitempty"`
+		*Alias
+	}{
+		Alias: (*Alias)(obj),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	obj.Actor = firstLink(aux.Actor)
+	obj.AttributedTo = firstLink(aux.AttributedTo)
+	obj.Audience = firstLink(aux.Audience)
+	obj.Icon = imageLink(aux.Icon)
+	obj.Image = imageLink(aux.Image)
+	obj.To = stringList(aux.To)
+	obj.Bto = stringList(aux.Bto)
+	obj.Cc = stringList(aux.Cc)
+	obj.Bcc = stringList(aux.Bcc)
+
+	var err error
+
+	if obj.InReplyTo, err = objectList(aux.InReplyTo); err != nil {
+		return err
+	}
+
+	if obj.Attachment, err = objectList(aux.Attachment); err != nil {
+		return err
+	}
+
+	if obj.Tag, err = objectList(aux.Tag); err != nil {
+		return err
+	}
+
+	if obj.Url, err = objectList(aux.Url); err != nil {
+		return err
+	}
+
+	if len(aux.Replies) > 0 && string(aux.Replies) != "null" {
+		obj.Replies = new(CollectionBase)
+
+		if err := json.Unmarshal(aux.Replies, &obj.Replies.Id); err != nil {
+			return json.Unmarshal(aux.Replies, obj.Replies)
+		}
+	}
+
+	if aux.Poster != nil {
+		obj.fromInterop(*aux.Poster, aux.Source, firstLink(aux.Thread))
+	}
+
+	return nil
+}
+
+// fromInterop turns a post another FChannel instance served as an
+// InteropNote back into the shape boards store: the board as actor, the
+// poster as author, the plain comment as content and the thread first in
+// inReplyTo.
+func (obj *ObjectBase) fromInterop(poster string, source *InteropSource, thread string) {
+	if obj.Actor == "" {
+		obj.Actor = obj.AttributedTo
+	}
+
+	obj.AttributedTo = poster
+
+	if source != nil && source.MediaType == "text/plain" {
+		obj.Content = util.EscapeString(source.Content)
+	}
+
+	if thread != "" && thread != obj.Id {
+		inReplyTo := []ObjectBase{{Id: thread}}
+
+		for _, e := range obj.InReplyTo {
+			if e.Id != thread {
+				inReplyTo = append(inReplyTo, e)
+			}
+		}
+
+		obj.InReplyTo = inReplyTo
+	}
+
+	for i, e := range obj.Attachment {
+		if e.Href == "" && len(e.Url) > 0 {
+			obj.Attachment[i].Href = e.Url[0].Id
+		}
+	}
+}
+
 type NestedObjectBase struct {
 	AtContext
 	Type         string          `json:"type,omitempty"`
@@ -187,21 +335,46 @@ type NestedObjectBase struct {
 	Size         int64           `json:"size,omitempty"`
 }
 
+// UnmarshalJSON for NestedObjectBase accepts a bare id, which is how most
+// software refers to the object of a Follow or Announce.
+func (nb *NestedObjectBase) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		nb.Id = id
+		return nil
+	}
+
+	type Alias NestedObjectBase // Prevent recursion
+	return json.Unmarshal(data, (*Alias)(nb))
+}
+
 type CollectionBase struct {
+	Id           string       `json:"id,omitempty"`
 	Actor        *Actor       `json:"actor,omitempty"`
 	Summary      string       `json:"summary,omitempty"`
 	Type         string       `json:"type,omitempty"`
 	TotalItems   int          `json:"totalItems,omitempty"`
 	TotalImgs    int          `json:"totalImgs,omitempty"`
+	First        string       `json:"first,omitempty"`
+	Last         string       `json:"last,omitempty"`
+	Next         string       `json:"next,omitempty"`
+	Prev         string       `json:"prev,omitempty"`
+	PartOf       string       `json:"partOf,omitempty"`
 	OrderedItems []ObjectBase `json:"orderedItems,omitempty"`
 	Items        []ObjectBase `json:"items,omitempty"`
 }
 
-// UnmarshalJSON for CollectionBase supports both string and object for the actor field.
+// UnmarshalJSON for CollectionBase supports both string and object for the
+// actor field and the page links.
 func (cb *CollectionBase) UnmarshalJSON(data []byte) error {
 	type Alias CollectionBase // Prevent recursion
 	aux := &struct {
-		Actor json.RawMessage `json:"actor,omitempty"`
+		Actor  json.RawMessage `json:"actor,omitempty"`
+		First  json.RawMessage `json:"first,omitempty"`
+		Last   json.RawMessage `json:"last,omitempty"`
+		Next   json.RawMessage `json:"next,omitempty"`
+		Prev   json.RawMessage `json:"prev,omitempty"`
+		PartOf json.RawMessage `json:"partOf,omitempty"`
 		*Alias
 	}{
 		Alias: (*Alias)(cb),
@@ -209,6 +382,11 @@ func (cb *CollectionBase) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, aux); err != nil {
 		return err
 	}
+	cb.First = collectionLink(aux.First)
+	cb.Last = collectionLink(aux.Last)
+	cb.Next = collectionLink(aux.Next)
+	cb.Prev = collectionLink(aux.Prev)
+	cb.PartOf = collectionLink(aux.PartOf)
 	// Handle actor field being string or object
 	if len(aux.Actor) == 0 || string(aux.Actor) == "null" {
 		cb.Actor = nil
@@ -227,6 +405,97 @@ func (cb *CollectionBase) UnmarshalJSON(data []byte) error {
 	return nil // fallback: ignore actor if not parseable
 }
 
+// collectionLink returns the id of a page link, which some software embeds as
+// the page itself.
+func collectionLink(data json.RawMessage) string {
+	var link struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.Unmarshal(data, &link.Id); err == nil {
+		return link.Id
+	}
+
+	json.Unmarshal(data, &link)
+
+	return link.Id
+}
+
+// objectList reads a single id, a single object or an array of either.
+func objectList(data json.RawMessage) ([]ObjectBase, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var items []json.RawMessage
+
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+
+	var list []ObjectBase
+
+	for _, e := range items {
+		var obj ObjectBase
+
+		if err := json.Unmarshal(e, &obj.Id); err != nil {
+			if err := json.Unmarshal(e, &obj); err != nil {
+				return list, err
+			}
+		}
+
+		list = append(list, obj)
+	}
+
+	return list, nil
+}
+
+// stringList reads a single id or an array of ids or objects.
+func stringList(data json.RawMessage) []string {
+	var items []json.RawMessage
+
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+
+	var list []string
+
+	for _, e := range items {
+		if id := collectionLink(e); id != "" {
+			list = append(list, id)
+		}
+	}
+
+	return list
+}
+
+// firstLink reads the id of a link that may be sent as an array.
+func firstLink(data json.RawMessage) string {
+	if list := stringList(data); len(list) > 0 {
+		return list[0]
+	}
+
+	return ""
+}
+
+// imageLink reads an icon or image, a url or an Image object with one.
+func imageLink(data json.RawMessage) string {
+	var image struct {
+		Url  json.RawMessage `json:"url"`
+		Href string          `json:"href"`
+	}
+
+	if err := json.Unmarshal(data, &image); err != nil {
+		return firstLink(data)
+	}
+
+	if image.Href != "" {
+		return image.Href
+	}
+
+	return firstLink(image.Url)
+}
+
 type Collection struct {
 	AtContext
 	CollectionBase
@@ -252,3 +521,16 @@ type ObjectBaseSortAsc []ObjectBase
 func (a ObjectBaseSortAsc) Len() int           { return len(a) }
 func (a ObjectBaseSortAsc) Less(i, j int) bool { return a[i].Published.Before(a[j].Published) }
 func (a ObjectBaseSortAsc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// UnmarshalJSON for Multikey accepts a bare key id, assertionMethod entries
+// are allowed to reference keys published elsewhere.
+func (mk *Multikey) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		mk.Id = id
+		return nil
+	}
+
+	type Alias Multikey // Prevent recursion
+	return json.Unmarshal(data, (*Alias)(mk))
+}
@@ -2,6 +2,7 @@ package activitypub
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -103,163 +104,189 @@ func CreateObject(objType string) ObjectBase {
 	return nObj
 }
 
+// GetActivityFromJson reads a posted activity, normalizing its JSON-LD
+// first. Documents that are not ActivityStreams activities give a
+// *ParseError.
 func GetActivityFromJson(ctx *fiber.Ctx) (Activity, error) {
 
 	var respActivity ActivityRaw
 	var nActivity Activity
 	var nType string
 
-	if err := json.Unmarshal(ctx.Body(), &respActivity); err != nil {
-		return nActivity, util.MakeError(err, "GetActivityFromJson")
+	body, err := NormalizeJSON(ctx.Body())
+
+	if err != nil {
+		return nActivity, err
 	}
 
-	if res, err := HasContextFromJson(respActivity.AtContextRaw.Context); err == nil && res {
-		var jObj ObjectBase
+	if err := json.Unmarshal(body, &respActivity); err != nil {
+		return nActivity, &ParseError{Err: err}
+	}
 
-		if respActivity.Type == "Note" {
-			jObj, err = GetObjectFromJson(ctx.Body())
-			if err != nil {
-				return nActivity, util.MakeError(err, "GetActivityFromJson")
-			}
+	if res, err := HasContextFromJson(respActivity.AtContextRaw.Context); err != nil {
+		return nActivity, err
+	} else if !res {
+		return nActivity, &ParseError{Field: "@context", Err: ErrNoActivityStreams}
+	}
 
-			nType = "Create"
-		} else {
-			jObj, err = GetObjectFromJson(respActivity.ObjectRaw)
-			if err != nil {
-				return nActivity, util.MakeError(err, "GetActivityFromJson")
-			}
+	if respActivity.Type == "" {
+		return nActivity, &ParseError{Field: "type", Err: errors.New("missing")}
+	}
 
-			nType = respActivity.Type
-		}
+	var jObj ObjectBase
 
-		actor, err := GetActorFromJson(respActivity.ActorRaw)
-		if err != nil {
-			return nActivity, util.MakeError(err, "GetActivityFromJson")
-		}
+	if respActivity.Type == "Note" {
+		jObj, err = GetObjectFromJson(body)
+		nType = "Create"
+	} else if respActivity.Type == "Flag" {
+		jObj, err = GetFlaggedObject(respActivity.ObjectRaw)
+		nType = respActivity.Type
+	} else {
+		jObj, err = GetObjectFromJson(respActivity.ObjectRaw)
+		nType = respActivity.Type
+	}
 
-		to, err := GetToFromJson(respActivity.ToRaw)
-		if err != nil {
-			return nActivity, util.MakeError(err, "GetActivityFromJson")
-		}
+	if err != nil {
+		return nActivity, &ParseError{Field: "object", Err: err}
+	}
 
-		cc, err := GetToFromJson(respActivity.CcRaw)
-		if err != nil {
-			return nActivity, util.MakeError(err, "GetActivityFromJson")
-		}
+	actor, err := GetActorFromJson(respActivity.ActorRaw)
+	if err != nil {
+		return nActivity, &ParseError{Field: "actor", Err: err}
+	}
 
-		nActivity.AtContext.Context = "https://www.w3.org/ns/activitystreams"
-		nActivity.Type = nType
-		nActivity.Actor = &actor
-		nActivity.Published = respActivity.Published
-		nActivity.Auth = respActivity.Auth
+	to, err := GetToFromJson(respActivity.ToRaw)
+	if err != nil {
+		return nActivity, &ParseError{Field: "to", Err: err}
+	}
 
-		if len(to) > 0 {
-			nActivity.To = to
-		}
+	cc, err := GetToFromJson(respActivity.CcRaw)
+	if err != nil {
+		return nActivity, &ParseError{Field: "cc", Err: err}
+	}
 
-		if len(cc) > 0 {
-			nActivity.Cc = cc
-		}
+	nActivity.AtContext.Context = "https://www.w3.org/ns/activitystreams"
+	nActivity.Type = nType
+	nActivity.Id = respActivity.Id
+	nActivity.Actor = &actor
+	nActivity.Published = respActivity.Published
+	nActivity.Auth = respActivity.Auth
+
+	if len(to) > 0 {
+		nActivity.To = to
+	}
 
-		nActivity.Name = respActivity.Name
-		nActivity.Object = jObj
-	} else if err != nil {
-		return nActivity, util.MakeError(err, "GetActivityFromJson")
+	if len(cc) > 0 {
+		nActivity.Cc = cc
 	}
 
+	nActivity.Name = respActivity.Name
+	nActivity.Content = respActivity.Content
+	nActivity.Target = firstLink(respActivity.TargetRaw)
+	nActivity.Object = jObj
+
 	return nActivity, nil
 }
 
+// GetObjectFromJson reads an object given as an id, an object or a list of
+// either, of which the first is taken.
 func GetObjectFromJson(obj []byte) (ObjectBase, error) {
 	var generic interface{}
 	var nObj ObjectBase
 
+	if len(obj) == 0 {
+		return nObj, nil
+	}
+
 	if err := json.Unmarshal(obj, &generic); err != nil {
 		return ObjectBase{}, util.MakeError(err, "GetObjectFromJson")
 	}
 
-	if generic != nil {
-		switch generic.(type) {
-		case []interface{}:
-			var lObj ObjectBase
-			var arrContext ObjectArray
-
-			if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
-				return nObj, util.MakeError(err, "GetObjectFromJson")
-			}
+	switch generic.(type) {
+	case nil:
+	case []interface{}:
+		var arrContext ObjectArray
 
-			if len(arrContext.Object) > 0 {
-				lObj = arrContext.Object[0]
-			}
-			nObj = lObj
+		if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
+			return ObjectBase{}, util.MakeError(err, "GetObjectFromJson")
+		}
 
-		case map[string]interface{}:
-			var arrContext Object
+		if len(arrContext.Object) > 0 {
+			nObj = arrContext.Object[0]
+		}
 
-			if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
-				return nObj, util.MakeError(err, "GetObjectFromJson")
-			}
+	case map[string]interface{}:
+		var arrContext Object
 
-			nObj = *arrContext.Object
+		if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
+			return ObjectBase{}, util.MakeError(err, "GetObjectFromJson")
+		}
 
-		case string:
-			var lObj ObjectBase
-			var arrContext ObjectString
+		nObj = *arrContext.Object
 
-			if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
-				return nObj, util.MakeError(err, "GetObjectFromJson")
-			}
+	case string:
+		var arrContext ObjectString
 
-			lObj.Id = arrContext.Object
-			nObj = lObj
+		if err := json.Unmarshal(obj, &arrContext.Object); err != nil {
+			return ObjectBase{}, util.MakeError(err, "GetObjectFromJson")
 		}
+
+		nObj.Id = arrContext.Object
+
+	default:
+		return ObjectBase{}, errors.New("not an id or object")
 	}
 
 	return nObj, nil
 }
 
+// HasContextFromJson reports whether an @context includes ActivityStreams,
+// given by its url, as a vocabulary or through terms defined in it.
 func HasContextFromJson(context []byte) (bool, error) {
 	var generic interface{}
 
-	err := json.Unmarshal(context, &generic)
-	if err != nil {
-		return false, util.MakeError(err, "HasContextFromJson")
+	if len(context) == 0 {
+		return false, nil
 	}
 
-	hasContext := false
+	if err := json.Unmarshal(context, &generic); err != nil {
+		return false, &ParseError{Field: "@context", Err: err}
+	}
 
-	switch generic.(type) {
-	case []interface{}:
-		var arrContext AtContextArray
-		err = json.Unmarshal(context, &arrContext.Context)
-		if len(arrContext.Context) > 0 {
-			if arrContext.Context[0] == "https://www.w3.org/ns/activitystreams" {
-				hasContext = true
-			}
-		}
+	var n jsonldNormalizer
 
-	case string:
-		var arrContext AtContextString
-		err = json.Unmarshal(context, &arrContext.Context)
-		if arrContext.Context == "https://www.w3.org/ns/activitystreams" {
-			hasContext = true
-		}
+	c, err := n.withContext(jsonldContext{}, generic)
+
+	if err != nil {
+		return false, err
+	}
+
+	if strings.HasPrefix(c.vocab, activityStreamsNS) {
+		return true, nil
 	}
 
-	return hasContext, util.MakeError(err, "HasContextFromJson")
+	for _, e := range c.terms {
+		n.expandIRI(c, e)
+	}
+
+	return n.activityStreams, nil
 }
 
 func GetActorByNameFromDB(name string) (Actor, error) {
 	var nActor Actor
 	var publicKeyPem string
 
-	query := `select type, id, preferredusername, name, inbox, outbox, following, followers, restricted, summary, publickeypem, boardtype, optionsmask from actor where preferredusername=$1`
-	err := config.DB.QueryRow(query, name).Scan(&nActor.Type, &nActor.Id, &nActor.PreferredUsername, &nActor.Name, &nActor.Inbox, &nActor.Outbox, &nActor.Following, &nActor.Followers, &nActor.Restricted, &nActor.Summary, &publicKeyPem, &nActor.BoardType, &nActor.OptionsMask)
+	var alsoKnownAs string
+
+	query := `select type, id, preferredusername, name, inbox, outbox, following, followers, restricted, summary, publickeypem, boardtype, optionsmask, alsoknownas, movedto from actor where preferredusername=$1`
+	err := config.DB.QueryRow(query, name).Scan(&nActor.Type, &nActor.Id, &nActor.PreferredUsername, &nActor.Name, &nActor.Inbox, &nActor.Outbox, &nActor.Following, &nActor.Followers, &nActor.Restricted, &nActor.Summary, &publicKeyPem, &nActor.BoardType, &nActor.OptionsMask, &alsoKnownAs, &nActor.MovedTo)
 
 	if err != nil {
 		return nActor, util.MakeError(err, "GetActorByNameFromDB")
 	}
 
+	nActor.AlsoKnownAs = strings.Fields(alsoKnownAs)
+
 	nActor.PublicKey, err = GetActorPemFromDB(publicKeyPem)
 
 	if err != nil {
@@ -288,7 +315,7 @@ func GetActorCollectionReq(collection string) (Collection, error) {
 	pass := "FIXME"
 	//_, pass := GetPasswordFromSession(r)
 
-	req.Header.Set("Accept", config.ActivityStreams)
+	req.Header.Set("Accept", config.FetchAccept)
 
 	req.Header.Set("Authorization", "Basic "+pass)
 
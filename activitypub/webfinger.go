@@ -2,6 +2,7 @@ package activitypub
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,6 +23,24 @@ type Webfinger struct {
 var Boards []Board
 var FollowingBoards []ObjectBase
 
+// RefreshBoards reloads the board lists used for navigation after the main
+// actor starts or stops following a board.
+func RefreshBoards() error {
+	actor, err := GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return util.MakeError(err, "RefreshBoards")
+	}
+
+	if FollowingBoards, err = actor.GetFollowing(); err != nil {
+		return util.MakeError(err, "RefreshBoards")
+	}
+
+	Boards, err = GetBoardCollection()
+
+	return util.MakeError(err, "RefreshBoards")
+}
+
 type WebfingerLink struct {
 	Rel  string `json:"rel,omitempty"`
 	Type string `json:"type,omitempty"`
@@ -53,6 +72,73 @@ func (a BoardSortAsc) Len() int           { return len(a) }
 func (a BoardSortAsc) Less(i, j int) bool { return a[i].PrefName < a[j].PrefName }
 func (a BoardSortAsc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
+// ActorCache is shared by the request handlers and the delivery workers, so
+// it is only touched through these helpers.
+func GetCachedActor(key string) (Actor, bool) {
+	actorCacheMutex.RLock()
+	defer actorCacheMutex.RUnlock()
+
+	actor := ActorCache[key]
+	return actor, actor.Id != ""
+}
+
+func SetCachedActor(key string, actor Actor) {
+	actorCacheMutex.Lock()
+	defer actorCacheMutex.Unlock()
+
+	ActorCache[key] = actor
+	actorCacheFetched[key] = time.Now()
+}
+
+func DeleteCachedActor(key string) {
+	actorCacheMutex.Lock()
+	defer actorCacheMutex.Unlock()
+
+	delete(ActorCache, key)
+	delete(actorCacheFetched, key)
+}
+
+// IsCachedActorStale reports whether the actor id is cached and was stored
+// longer than age ago.
+func IsCachedActorStale(id string, age time.Duration) bool {
+	name, instance := GetActorAndInstance(id)
+
+	actorCacheMutex.RLock()
+	defer actorCacheMutex.RUnlock()
+
+	fetched, ok := actorCacheFetched[name+"@"+instance]
+	return ok && time.Since(fetched) > age
+}
+
+// RefreshActor fetches a remote actor again instead of using the cached copy.
+// If its key changed the old one is kept for the rotation grace period, so
+// activities signed before the rotation still verify.
+func RefreshActor(id string) (Actor, error) {
+	name, instance := GetActorAndInstance(id)
+	key := name + "@" + instance
+
+	cached, ok := GetCachedActor(key)
+	DeleteCachedActor(key)
+
+	actor, err := GetActor(id)
+
+	if err != nil || actor.Id == "" {
+		if ok {
+			SetCachedActor(key, cached)
+		}
+
+		return actor, util.MakeError(err, "RefreshActor")
+	}
+
+	if ok && cached.PublicKey != nil && cached.PublicKey.PublicKeyPem != "" && (actor.PublicKey == nil || actor.PublicKey.Id != cached.PublicKey.Id) {
+		if err := StoreRemoteKey(*cached.PublicKey); err != nil {
+			return actor, util.MakeError(err, "RefreshActor")
+		}
+	}
+
+	return actor, nil
+}
+
 func GetActor(id string) (Actor, error) {
 	var respActor Actor
 
@@ -60,11 +146,14 @@ func GetActor(id string) (Actor, error) {
 		return respActor, nil
 	}
 
+	if !util.IsInstanceAllowed(id) {
+		return respActor, util.MakeError(errors.New("instance of "+id+" is not federated with"), "GetActor")
+	}
+
 	actor, instance := GetActorAndInstance(id)
 
-	if ActorCache[actor+"@"+instance].Id != "" {
-		respActor = ActorCache[actor+"@"+instance]
-		return respActor, nil
+	if cached, ok := GetCachedActor(actor + "@" + instance); ok {
+		return cached, nil
 	}
 
 	req, err := http.NewRequest("GET", strings.TrimSpace(id), nil)
@@ -72,7 +161,8 @@ func GetActor(id string) (Actor, error) {
 		return respActor, util.MakeError(err, "GetActor")
 	}
 
-	req.Header.Set("Accept", config.ActivityStreams)
+	req.Header.Set("Accept", config.FetchAccept)
+	SignFetch(req)
 
 	resp, err := util.RouteProxy(req)
 
@@ -87,7 +177,7 @@ func GetActor(id string) (Actor, error) {
 		return respActor, util.MakeError(err, "GetActor")
 	}
 
-	ActorCache[actor+"@"+instance] = respActor
+	SetCachedActor(actor+"@"+instance, respActor)
 
 	return respActor, nil
 }
@@ -102,8 +192,12 @@ func FingerActor(path string) (Actor, error) {
 		return nActor, nil
 	}
 
-	if ActorCache[actor+"@"+instance].Id != "" {
-		nActor = ActorCache[actor+"@"+instance]
+	if !util.IsInstanceAllowed(instance) {
+		return nActor, util.MakeError(errors.New("instance "+instance+" is not federated with"), "FingerActor")
+	}
+
+	if cached, ok := GetCachedActor(actor + "@" + instance); ok {
+		nActor = cached
 	} else {
 		resp, err := FingerRequest(actor, instance)
 		if err != nil {
@@ -122,7 +216,7 @@ func FingerActor(path string) (Actor, error) {
 				return nActor, util.MakeError(err, "FingerActor unmarshal")
 			}
 
-			ActorCache[actor+"@"+instance] = nActor
+			SetCachedActor(actor+"@"+instance, nActor)
 		}
 	}
 
@@ -171,7 +265,9 @@ func FingerRequest(actor string, instance string) (*http.Response, error) {
 		}
 	}
 
-	req.Header.Set("Accept", config.ActivityStreams)
+	req.Header.Set("Accept", config.FetchAccept)
+	SignFetch(req)
+
 	if resp, err = util.RouteProxy(req); err != nil {
 		return resp, util.MakeError(err, "FingerRequest")
 	}
@@ -0,0 +1,333 @@
+// Command relay is a small stand-in for a LitePub style ActivityPub relay,
+// for trying relay subscriptions against local instances. Instances follow
+// its actor, and whatever any actor on a subscribed instance posts to its
+// inbox is announced to the other instances, the way real relays match
+// senders by their host.
+//
+// It keeps its subscribers in memory and does not check the signatures on
+// what it is sent, so it must not be exposed to the internet.
+//
+//	go run ./cmd/relay -addr :8080 -domain http://192.168.0.2:8080
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const activityType = "application/activity+json"
+
+var (
+	addr   = flag.String("addr", ":8080", "address to listen on")
+	domain = flag.String("domain", "http://localhost:8080", "url the relay is reached at by the instances")
+)
+
+type relay struct {
+	actor string
+	key   *rsa.PrivateKey
+	pem   string
+
+	mu sync.Mutex
+	// Follower actor ids to the inbox deliveries go to
+	subscribers map[string]string
+}
+
+type activity struct {
+	Id     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+func main() {
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := &relay{
+		actor:       strings.TrimSuffix(*domain, "/") + "/actor",
+		key:         key,
+		pem:         string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		subscribers: make(map[string]string),
+	}
+
+	http.HandleFunc("/actor", r.serveActor)
+	http.HandleFunc("/inbox", r.serveInbox)
+
+	log.Println("relay actor " + r.actor + " listening on " + *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (r *relay) serveActor(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", activityType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"@context":          []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		"id":                r.actor,
+		"type":              "Application",
+		"preferredUsername": "relay",
+		"name":              "Relay",
+		"inbox":             strings.TrimSuffix(r.actor, "/actor") + "/inbox",
+		"publicKey": map[string]string{
+			"id":           r.actor + "#main-key",
+			"owner":        r.actor,
+			"publicKeyPem": r.pem,
+		},
+	})
+}
+
+func (r *relay) serveInbox(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var a activity
+	if err := json.Unmarshal(body, &a); err != nil || a.Actor == "" {
+		http.Error(w, "not an activity", http.StatusBadRequest)
+		return
+	}
+
+	log.Println("received " + a.Type + " from " + a.Actor)
+
+	switch a.Type {
+	case "Follow":
+		inbox, err := getInbox(a.Actor)
+
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.subscribers[a.Actor] = inbox
+		r.mu.Unlock()
+
+		accept := map[string]interface{}{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"id":       r.actor + "#accepts/" + randomID(),
+			"type":     "Accept",
+			"actor":    r.actor,
+			"object":   json.RawMessage(body),
+			"to":       []string{a.Actor},
+		}
+
+		go r.deliver(inbox, accept)
+	case "Undo":
+		r.mu.Lock()
+		delete(r.subscribers, a.Actor)
+		r.mu.Unlock()
+	case "Create", "Announce":
+		host := hostOf(a.Actor)
+
+		r.mu.Lock()
+		subscribed := false
+		for follower := range r.subscribers {
+			if hostOf(follower) == host {
+				subscribed = true
+			}
+		}
+		r.mu.Unlock()
+
+		if !subscribed {
+			log.Println("ignoring " + a.Type + " from " + a.Actor + ", its instance is not subscribed")
+			break
+		}
+
+		id := objectID(a.Object)
+
+		if id == "" {
+			break
+		}
+
+		announce := map[string]interface{}{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"id":       r.actor + "#announces/" + randomID(),
+			"type":     "Announce",
+			"actor":    r.actor,
+			"object":   id,
+			"to":       []string{"https://www.w3.org/ns/activitystreams#Public"},
+		}
+
+		r.mu.Lock()
+		for follower, inbox := range r.subscribers {
+			if hostOf(follower) != host {
+				go r.deliver(inbox, announce)
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deliver posts an activity signed with the relay's key.
+func (r *relay) deliver(inbox string, v interface{}) {
+	if err := r.post(inbox, v); err != nil {
+		log.Println("delivering to " + inbox + ": " + err.Error())
+	}
+}
+
+func (r *relay) post(inbox string, v interface{}) error {
+	payload, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(inbox)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(payload))
+
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(payload)
+	digest := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+	date := time.Now().UTC().Format(http.TimeFormat)
+
+	signed := "(request-target): post " + u.RequestURI() + "\n" +
+		"host: " + u.Host + "\n" +
+		"date: " + date + "\n" +
+		"digest: " + digest
+
+	hashed := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, r.key, crypto.SHA256, hashed[:])
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", activityType)
+	req.Header.Set("Date", date)
+	req.Header.Set("Digest", digest)
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s#main-key",algorithm="rsa-sha256",headers="(request-target) host date digest",signature="%s"`, r.actor, base64.StdEncoding.EncodeToString(sig)))
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// getInbox fetches an actor for the inbox to deliver to, preferring a
+// shared one.
+func getInbox(id string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, id, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", activityType)
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	var actor struct {
+		Inbox     string `json:"inbox"`
+		Endpoints struct {
+			SharedInbox string `json:"sharedInbox"`
+		} `json:"endpoints"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+		return "", err
+	}
+
+	if actor.Endpoints.SharedInbox != "" {
+		return actor.Endpoints.SharedInbox, nil
+	}
+
+	if actor.Inbox == "" {
+		return "", errors.New("no inbox for " + id)
+	}
+
+	return actor.Inbox, nil
+}
+
+// hostOf returns the host of an actor id, which is what subscriptions are
+// matched by.
+func hostOf(id string) string {
+	u, err := url.Parse(id)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Host)
+}
+
+// objectID returns the id of the post a Create or Announce carries.
+func objectID(data json.RawMessage) string {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		return id
+	}
+
+	var object struct {
+		Id string `json:"id"`
+	}
+	json.Unmarshal(data, &object)
+
+	return object.Id
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return fmt.Sprintf("%x", b)
+}
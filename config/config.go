@@ -32,6 +32,7 @@ var DBPassword = GetConfigValue("dbpass", "password")
 var DBName = GetConfigValue("dbname", "server")
 var CookieKey = GetConfigValue("cookiekey", "")
 var ActivityStreams = "application/ld+json; profile=\"https://www.w3.org/ns/activitystreams\""
+var FetchAccept = ActivityStreams + ", application/activity+json; profile=\"https://github.com/anomalous69/FChannel/ns\""
 var AuthReq = []string{"captcha", "email", "passphrase"}
 var PostCountPerPage = 10
 var SupportedFiles = []string{"image/avif", "image/gif", "image/jpeg", "image/jxl", "image/png", "image/webp", "video/mp4", "video/ogg", "video/webm", "audio/mpeg", "audio/ogg", "audio/wav", "audio/wave", "audio/x-wav", "application/x-shockwave-flash"}
@@ -40,6 +41,20 @@ var MediaHashs = make(map[string]string)
 var Key = GetConfigValue("modkey", "")
 var MinPostDelete = GetConfigValue("minpostdelete", "60")
 var MaxPostDelete = GetConfigValue("maxpostdelete", "1800")
+var MaxPostEdit = GetConfigValue("maxpostedit", "600")
+var DeliveryWorkers, _ = strconv.Atoi(GetConfigValue("deliveryworkers", "4"))
+var DeliveryMaxAttempts, _ = strconv.Atoi(GetConfigValue("deliveryattempts", "12"))
+var KeyRotationGrace, _ = strconv.Atoi(GetConfigValue("keyrotationgrace", "604800"))
+var Federation = GetConfigValue("federation", "open")
+var AuthorizedFetch = GetConfigValue("authorizedfetch", "false") == "true"
+var BackfillThreads, _ = strconv.Atoi(GetConfigValue("backfillthreads", "100"))
+var BackfillDays, _ = strconv.Atoi(GetConfigValue("backfilldays", "0"))
+var BackfillDelay, _ = strconv.Atoi(GetConfigValue("backfilldelay", "5"))
+var SeenRetention, _ = strconv.Atoi(GetConfigValue("seenretention", "604800"))
+var InboxWorkers, _ = strconv.Atoi(GetConfigValue("inboxworkers", "4"))
+var InboxMaxAttempts, _ = strconv.Atoi(GetConfigValue("inboxattempts", "8"))
+var DeadFollowerDays, _ = strconv.Atoi(GetConfigValue("deadfollowerdays", "7"))
+var FederationLogSize, _ = strconv.Atoi(GetConfigValue("federationlog", "1000"))
 
 // TODO: this is bad but I don't feel like doing a new config system yet, and I can't into computers
 var MaxAttachmentSize, _ = strconv.Atoi(GetConfigValue("maxattachsize", "7340032"))
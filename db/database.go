@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"os"
@@ -163,6 +164,85 @@ func CreateNewBoard(actor activitypub.Actor) (activitypub.Actor, error) {
 	return actor, nil
 }
 
+var boardNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// ImportBoard creates a board from another instance's export under name, or
+// the name it had there. The new board lists the old one in alsoKnownAs so it
+// can be moved here, follows what it followed and shows its threads.
+func ImportBoard(export activitypub.BoardExport, name string) (activitypub.Actor, error) {
+	board := export.Board
+
+	if board.Id == "" {
+		return activitypub.Actor{}, errors.New("export has no board")
+	}
+
+	if name == "" {
+		name = board.PreferredUsername
+	}
+
+	// The name from the export is as untrusted as one typed in
+	if !boardNamePattern.MatchString(name) {
+		return activitypub.Actor{}, errors.New("board name can only contain letters and numbers")
+	}
+
+	actor, err := CreateNewBoard(*activitypub.CreateNewActor(name, board.Name, board.Summary, board.AuthRequirement, board.Restricted, board.BoardType, board.OptionsMask))
+
+	if err != nil {
+		return actor, util.MakeError(err, "ImportBoard")
+	}
+
+	if actor.Id == "" {
+		return actor, errors.New("board /" + name + "/ already exists")
+	}
+
+	if err := actor.SetAlsoKnownAs(append(board.AlsoKnownAs, board.Id)); err != nil {
+		return actor, util.MakeError(err, "ImportBoard")
+	}
+
+	for _, e := range export.Threads {
+		e.Actor = actor.Id
+
+		// Caching attachments expects a preview
+		if len(e.Attachment) > 0 && e.Preview == nil {
+			e.Preview = &activitypub.NestedObjectBase{}
+		}
+
+		if e.Replies != nil {
+			for i, k := range e.Replies.OrderedItems {
+				e.Replies.OrderedItems[i].Actor = actor.Id
+
+				if len(k.Attachment) > 0 && k.Preview == nil {
+					e.Replies.OrderedItems[i].Preview = &activitypub.NestedObjectBase{}
+				}
+			}
+		}
+
+		if _, err := e.WriteCache(); err != nil {
+			return actor, util.MakeError(err, "ImportBoard")
+		}
+	}
+
+	for _, e := range export.Following {
+		if e == board.Id || !util.IsInstanceAllowed(e) {
+			continue
+		}
+
+		follow, err := actor.MakeFollowActivity(e)
+
+		if err != nil {
+			return actor, util.MakeError(err, "ImportBoard")
+		}
+
+		if err := follow.MakeRequestOutbox(); err != nil {
+			return actor, util.MakeError(err, "ImportBoard")
+		}
+	}
+
+	config.Log.Println("Board /" + actor.PreferredUsername + "/ imported from " + board.Id)
+
+	return actor, util.MakeError(activitypub.RefreshBoards(), "ImportBoard")
+}
+
 func RemovePreviewFromFile(id string) error {
 	var href string
 
@@ -254,42 +334,6 @@ func WriteNews(news NewsItem) error {
 	return util.MakeError(err, "WriteNews")
 }
 
-func AddInstanceToInactive(instance string) error {
-	var timeStamp string
-
-	query := `select timestamp from inactive where instance=$1`
-	if err := config.DB.QueryRow(query, instance).Scan(&timeStamp); err != nil {
-		query := `insert into inactive (instance, timestamp) values ($1, $2)`
-		_, err := config.DB.Exec(query, instance, time.Now().UTC().Format(time.RFC3339))
-
-		return util.MakeError(err, "AddInstanceToInactive")
-	}
-
-	if !IsInactiveTimestamp(timeStamp) {
-		return nil
-	}
-
-	query = `delete from follower where follower like $1`
-	if _, err := config.DB.Exec(query, "%"+instance+"%"); err != nil {
-		return util.MakeError(err, "AddInstanceToInactive")
-	}
-
-	err := DeleteInstanceFromInactive(instance)
-	return util.MakeError(err, "AddInstanceToInactive")
-}
-
-func DeleteInstanceFromInactive(instance string) error {
-	query := `delete from inactive where instance=$1`
-	_, err := config.DB.Exec(query, instance)
-
-	return util.MakeError(err, "DeleteInstanceFromInactive")
-}
-
-func IsInactiveTimestamp(timeStamp string) bool {
-	stamp, _ := time.Parse(time.RFC3339, timeStamp)
-	return time.Now().UTC().Sub(stamp).Hours() > 48
-}
-
 func IsReplyToOP(op string, link string) (string, bool, error) {
 	var id string
 
@@ -325,74 +369,6 @@ func GetReplyOP(link string) (string, error) {
 	return id, nil
 }
 
-func CheckInactive() {
-	for {
-		CheckInactiveInstances()
-		time.Sleep(24 * time.Hour)
-	}
-}
-
-func CheckInactiveInstances() (map[string]string, error) {
-	var rows *sql.Rows
-	var err error
-
-	instances := make(map[string]string)
-
-	query := `select following from following`
-	if rows, err = config.DB.Query(query); err != nil {
-		return instances, util.MakeError(err, "CheckInactiveInstances")
-	}
-
-	defer rows.Close()
-	for rows.Next() {
-		var instance string
-
-		if err := rows.Scan(&instance); err != nil {
-			return instances, util.MakeError(err, "CheckInactiveInstances")
-		}
-
-		instances[instance] = instance
-	}
-
-	query = `select follower from follower`
-	if rows, err = config.DB.Query(query); err != nil {
-		return instances, util.MakeError(err, "CheckInactiveInstances")
-	}
-
-	defer rows.Close()
-	for rows.Next() {
-		var instance string
-
-		if err := rows.Scan(&instance); err != nil {
-			return instances, util.MakeError(err, "CheckInactiveInstances")
-		}
-
-		instances[instance] = instance
-	}
-
-	re := regexp.MustCompile(config.Domain + `(.+)?`)
-
-	for _, e := range instances {
-		actor, err := activitypub.GetActor(e)
-
-		if err != nil {
-			return instances, util.MakeError(err, "CheckInactiveInstances")
-		}
-
-		if actor.Id == "" && !re.MatchString(e) {
-			if err := AddInstanceToInactive(e); err != nil {
-				return instances, util.MakeError(err, "CheckInactiveInstances")
-			}
-		} else {
-			if err := DeleteInstanceFromInactive(e); err != nil {
-				return instances, util.MakeError(err, "CheckInactiveInstances")
-			}
-		}
-	}
-
-	return instances, nil
-}
-
 func GetAdminAuth() (string, string, error) {
 	var code string
 	var identifier string
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_deliveryqueue_status_next;
+DROP TABLE IF EXISTS deliveryqueue;
@@ -0,0 +1,15 @@
+-- Outbound federation deliveries, one row per recipient inbox
+CREATE TABLE IF NOT EXISTS deliveryqueue(
+id serial PRIMARY KEY,
+actor varchar(100) NOT NULL,
+inbox varchar(255) NOT NULL,
+payload text NOT NULL,
+status varchar(16) NOT NULL default 'pending',
+attempts int NOT NULL default 0,
+next_attempt TIMESTAMP NOT NULL default NOW(),
+last_error text NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW(),
+updated TIMESTAMP NOT NULL default NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_deliveryqueue_status_next ON deliveryqueue(status, next_attempt);
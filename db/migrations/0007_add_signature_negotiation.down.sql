@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS instancesignature;
+DELETE FROM publickeypem WHERE type != 'rsa';
+ALTER TABLE publickeypem DROP COLUMN IF EXISTS type;
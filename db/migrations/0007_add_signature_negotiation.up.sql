@@ -0,0 +1,10 @@
+-- Key type so boards can hold an Ed25519 key next to the RSA main key
+ALTER TABLE publickeypem ADD COLUMN IF NOT EXISTS type varchar(16) NOT NULL DEFAULT 'rsa';
+
+-- HTTP signature scheme negotiated with each remote instance
+CREATE TABLE IF NOT EXISTS instancesignature(
+instance varchar(100) PRIMARY KEY,
+scheme varchar(16) NOT NULL default 'cavage',
+algorithm varchar(32) NOT NULL default '',
+updated TIMESTAMP NOT NULL default NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS remotekey;
+ALTER TABLE publickeypem DROP COLUMN IF EXISTS expires;
@@ -0,0 +1,10 @@
+-- Keys rotated out of use are still accepted for verification until they expire
+ALTER TABLE publickeypem ADD COLUMN IF NOT EXISTS expires TIMESTAMP;
+
+-- Previous keys of remote actors, kept for a while after they rotate
+CREATE TABLE IF NOT EXISTS remotekey(
+id varchar(255) PRIMARY KEY,
+owner varchar(100) NOT NULL,
+pem text NOT NULL,
+expires TIMESTAMP NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Instances we refuse to federate with, subdomains are blocked along with the domain
+CREATE TABLE IF NOT EXISTS instanceblock(
+id serial PRIMARY KEY,
+domain varchar(255) NOT NULL UNIQUE,
+reason text NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW()
+);
@@ -0,0 +1,6 @@
+-- Instances we federate with when federation is set to allowlist
+CREATE TABLE IF NOT EXISTS instanceallow(
+id serial PRIMARY KEY,
+domain varchar(255) NOT NULL UNIQUE,
+created TIMESTAMP NOT NULL default NOW()
+);
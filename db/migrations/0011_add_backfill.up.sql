@@ -0,0 +1,14 @@
+-- Background import of a followed board's outbox, page holds the next page to fetch
+CREATE TABLE IF NOT EXISTS backfill(
+id serial PRIMARY KEY,
+actor varchar(100) NOT NULL,
+target varchar(255) NOT NULL,
+page varchar(255) NOT NULL default '',
+threads int NOT NULL default 0,
+status varchar(16) NOT NULL default 'pending',
+last_error text NOT NULL default '',
+failures int NOT NULL default 0,
+created TIMESTAMP NOT NULL default NOW(),
+updated TIMESTAMP NOT NULL default NOW(),
+UNIQUE (actor, target)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS seensignature;
+DROP TABLE IF EXISTS seenactivity;
@@ -0,0 +1,11 @@
+-- Activities already processed, so repeated deliveries are only acknowledged
+CREATE TABLE IF NOT EXISTS seenactivity(
+id varchar(512) PRIMARY KEY,
+created TIMESTAMP NOT NULL default NOW()
+);
+
+-- Hashes of inbox request signatures seen while they could still verify
+CREATE TABLE IF NOT EXISTS seensignature(
+hash varchar(64) PRIMARY KEY,
+created TIMESTAMP NOT NULL default NOW()
+);
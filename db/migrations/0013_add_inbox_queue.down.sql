@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_inboxqueue_status_next;
+DROP TABLE IF EXISTS inboxqueue;
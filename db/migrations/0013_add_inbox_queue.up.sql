@@ -0,0 +1,15 @@
+-- Verified inbound activities waiting to be processed, dead ones are kept for the admin
+CREATE TABLE IF NOT EXISTS inboxqueue(
+id serial PRIMARY KEY,
+actor varchar(255) NOT NULL,
+type varchar(32) NOT NULL,
+payload text NOT NULL,
+status varchar(16) NOT NULL default 'pending',
+attempts int NOT NULL default 0,
+next_attempt TIMESTAMP NOT NULL default NOW(),
+last_error text NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW(),
+updated TIMESTAMP NOT NULL default NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_inboxqueue_status_next ON inboxqueue(status, next_attempt);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS inactive(
+instance varchar(100) primary key,
+timestamp TIMESTAMP default NOW()
+);
+
+DROP TABLE IF EXISTS instancehealth;
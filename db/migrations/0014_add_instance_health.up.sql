@@ -0,0 +1,16 @@
+-- Federation stats per remote instance, recorded from inbox and delivery traffic
+CREATE TABLE IF NOT EXISTS instancehealth(
+instance varchar(255) PRIMARY KEY,
+last_inbound TIMESTAMP,
+last_outbound TIMESTAMP,
+delivered int NOT NULL default 0,
+failed int NOT NULL default 0,
+last_error text NOT NULL default '',
+last_error_at TIMESTAMP,
+software varchar(100) NOT NULL default '',
+version varchar(100) NOT NULL default '',
+paused boolean NOT NULL default false,
+created TIMESTAMP NOT NULL default NOW()
+);
+
+DROP TABLE IF EXISTS inactive;
@@ -0,0 +1,15 @@
+-- Recent inbound and outbound ActivityPub requests, capped by the federationlog setting
+CREATE TABLE IF NOT EXISTS federationlog(
+id serial PRIMARY KEY,
+direction varchar(8) NOT NULL,
+instance text NOT NULL default '',
+actor text NOT NULL default '',
+type text NOT NULL default '',
+url text NOT NULL default '',
+status int NOT NULL default 0,
+verified varchar(16) NOT NULL default '',
+headers text NOT NULL default '',
+payload text NOT NULL default '',
+error text NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW()
+);
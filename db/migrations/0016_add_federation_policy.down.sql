@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS heldactivity;
+DROP TABLE IF EXISTS federationpolicy;
@@ -0,0 +1,17 @@
+-- Inbound policies, scoped to a remote instance or a single remote board
+CREATE TABLE IF NOT EXISTS federationpolicy(
+id serial PRIMARY KEY,
+scope varchar(255) NOT NULL,
+policy varchar(32) NOT NULL,
+value text NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW()
+);
+
+-- Inbound activities held for review by a hold policy
+CREATE TABLE IF NOT EXISTS heldactivity(
+id serial PRIMARY KEY,
+actor varchar(255) NOT NULL,
+policy varchar(32) NOT NULL,
+payload text NOT NULL,
+created TIMESTAMP NOT NULL default NOW()
+);
@@ -0,0 +1 @@
+ALTER TABLE cacheactivitystream ALTER COLUMN href TYPE varchar(100) USING left(href, 100);
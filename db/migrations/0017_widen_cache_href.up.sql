@@ -0,0 +1,2 @@
+-- Media urls on microblog software run past 100 characters
+ALTER TABLE cacheactivitystream ALTER COLUMN href TYPE varchar(512);
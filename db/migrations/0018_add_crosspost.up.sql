@@ -0,0 +1,10 @@
+-- Threads from other boards shown on a board, through an Announce
+CREATE TABLE IF NOT EXISTS crosspost(
+board varchar(100) NOT NULL,
+object varchar(512) NOT NULL,
+origin varchar(100) NOT NULL,
+announcer varchar(100) NOT NULL,
+activity varchar(512) NOT NULL default '',
+created TIMESTAMP NOT NULL default NOW(),
+PRIMARY KEY (board, object)
+);
@@ -0,0 +1 @@
+ALTER TABLE reported DROP COLUMN IF EXISTS instance;
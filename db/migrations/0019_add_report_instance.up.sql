@@ -0,0 +1,2 @@
+-- Reports forwarded by other instances as a Flag name where they came from
+ALTER TABLE reported ADD COLUMN IF NOT EXISTS instance varchar(100) NOT NULL default '';
@@ -0,0 +1,2 @@
+ALTER TABLE actor DROP COLUMN IF EXISTS movedto;
+ALTER TABLE actor DROP COLUMN IF EXISTS alsoknownas;
@@ -0,0 +1,3 @@
+-- Boards keep the ids they were known by elsewhere and where they moved to
+ALTER TABLE actor ADD COLUMN IF NOT EXISTS alsoknownas text NOT NULL default '';
+ALTER TABLE actor ADD COLUMN IF NOT EXISTS movedto varchar(100) NOT NULL default '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS relayboard;
+DROP TABLE IF EXISTS relay;
@@ -0,0 +1,20 @@
+-- Relays the instance actor subscribes to, by the actor or inbox url an admin added
+CREATE TABLE IF NOT EXISTS relay(
+id serial PRIMARY KEY,
+url varchar(512) NOT NULL UNIQUE,
+actor varchar(512) NOT NULL default '',
+inbox varchar(512) NOT NULL,
+follow varchar(512) NOT NULL,
+status varchar(20) NOT NULL default 'pending',
+created TIMESTAMP NOT NULL default NOW()
+);
+
+-- Remote boards seen in posts relays announced
+CREATE TABLE IF NOT EXISTS relayboard(
+id varchar(512) PRIMARY KEY,
+relay varchar(512) NOT NULL,
+name varchar(100) NOT NULL default '',
+preferredusername varchar(100) NOT NULL default '',
+summary varchar(200) NOT NULL default '',
+lastseen TIMESTAMP NOT NULL default NOW()
+);
@@ -0,0 +1 @@
+ALTER TABLE deliveryqueue DROP COLUMN IF EXISTS resolve;
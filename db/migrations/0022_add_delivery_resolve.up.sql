@@ -0,0 +1,3 @@
+-- Deliveries to actors that were not cached yet, the worker looks up their
+-- shared inbox before sending
+ALTER TABLE deliveryqueue ADD COLUMN IF NOT EXISTS resolve boolean NOT NULL default false;
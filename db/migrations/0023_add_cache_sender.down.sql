@@ -0,0 +1 @@
+ALTER TABLE cacheactivitystream DROP COLUMN IF EXISTS sender;
@@ -0,0 +1,3 @@
+-- Who sent a post cached from other software, the board it landed on is
+-- kept in actor
+ALTER TABLE cacheactivitystream ADD COLUMN IF NOT EXISTS sender varchar(512) NOT NULL default '';
@@ -12,12 +12,15 @@ type Reports struct {
 	Actor  activitypub.Actor
 	Object activitypub.ObjectBase
 	OP     string
-	Reason []string
+	Reason []Report
 }
 
+// A Report is one reason a post was reported for, Instance names the instance
+// that forwarded it and is empty for our own users' reports.
 type Report struct {
-	ID     string
-	Reason string
+	ID       string
+	Reason   string
+	Instance string
 }
 
 type Removed struct {
@@ -43,7 +46,7 @@ func CreateLocalReport(id string, board string, reason string) error {
 func GetLocalReport(board string) (map[string]Reports, error) {
 	var reported = make(map[string]Reports)
 
-	query := `select id, reason from reported where board=$1`
+	query := `select id, reason, instance from reported where board=$1`
 	rows, err := config.DB.Query(query, board)
 
 	if err != nil {
@@ -54,13 +57,13 @@ func GetLocalReport(board string) (map[string]Reports, error) {
 	for rows.Next() {
 		var r Report
 
-		if err := rows.Scan(&r.ID, &r.Reason); err != nil {
+		if err := rows.Scan(&r.ID, &r.Reason, &r.Instance); err != nil {
 			return reported, util.MakeError(err, "GetLocalReportDB")
 		}
 
 		if report, has := reported[r.ID]; has {
 			report.Count += 1
-			report.Reason = append(report.Reason, r.Reason)
+			report.Reason = append(report.Reason, r)
 			reported[r.ID] = report
 			continue
 		}
@@ -81,7 +84,7 @@ func GetLocalReport(board string) (map[string]Reports, error) {
 			Object: col.OrderedItems[0],
 			OP:     OP,
 			Actor:  activitypub.Actor{Name: board, Outbox: config.Domain + "/" + board + "/outbox"},
-			Reason: []string{r.Reason},
+			Reason: []Report{r},
 		}
 	}
 
@@ -66,12 +66,14 @@ func main() {
 		Except: []string{"csrf_", "theme"},
 	}))
 
+	app.Use(routes.SignedFetchFilter)
+
 	app.Static("/static", "./static")
 	app.Static("/public", "./public")
 
 	// Main actor
 	app.Get("/", routes.Index)
-	app.Post("/inbox", routes.Inbox)
+	app.Post("/inbox", routes.FederationLogger, routes.Inbox)
 	app.Post("/outbox", routes.Outbox)
 	app.Get("/following", routes.Following)
 	app.Get("/followers", routes.Followers)
@@ -83,13 +85,25 @@ func main() {
 	app.All("/"+config.Key+"/follow", routes.AdminFollow)
 	app.Post("/"+config.Key+"/addboard", routes.AdminAddBoard)
 	app.Post("/"+config.Key+"/newspost", routes.NewsPost)
+	app.Get("/"+config.Key+"/delivery", routes.AdminDelivery)
+	app.Get("/"+config.Key+"/inboxqueue", routes.AdminInboxQueue)
+	app.Get("/"+config.Key+"/instancehealth", routes.AdminInstanceHealth)
+	app.All("/"+config.Key+"/instanceblock", routes.AdminInstanceBlock)
+	app.All("/"+config.Key+"/instanceallow", routes.AdminInstanceAllow)
+	app.All("/"+config.Key+"/federationpolicy", routes.AdminFederationPolicy)
+	app.All("/"+config.Key+"/relay", routes.AdminRelay)
+	app.Post("/"+config.Key+"/import", routes.AdminImport)
 	app.Get("/"+config.Key+"/newsdelete/:ts", routes.NewsDelete)
 	app.Post("/"+config.Key+"/:actor/addjanny", routes.AdminAddJanny)
 	app.Post("/"+config.Key+"/:actor/editsummary", routes.AdminEditSummary)
 	app.Post("/"+config.Key+"/:actor/setboardtype", routes.AdminSetBoardType)
 	app.Post("/"+config.Key+"/:actor/setboardoptions", routes.AdminSetBoardOptions)
+	app.Post("/"+config.Key+"/:actor/rotatekey", routes.AdminRotateKey)
 	app.Get("/"+config.Key+"/:actor/deletejanny", routes.AdminDeleteJanny)
 	app.All("/"+config.Key+"/:actor/follow", routes.AdminFollow)
+	app.All("/"+config.Key+"/:actor/crosspost", routes.AdminCrosspost)
+	app.Get("/"+config.Key+"/:actor/export", routes.AdminExport)
+	app.Post("/"+config.Key+"/:actor/move", routes.AdminMove)
 	app.Get("/"+config.Key+"/:actor", routes.AdminActorIndex)
 
 	app.Get("/banned", routes.BannedGet)
@@ -112,6 +126,8 @@ func main() {
 	app.All("/blacklist", routes.BoardBlacklist)
 	app.All("/report", routes.ReportPost)
 	app.Get("/make-report", routes.ReportGet)
+	app.Get("/edit", routes.EditGet)
+	app.Post("/edit", routes.EditPost)
 	app.Get("/sticky", routes.Sticky)
 	app.Get("/lock", routes.Lock)
 
@@ -130,7 +146,7 @@ func main() {
 	// Board actor routes
 	app.Post("/post", routes.MakeActorPost)
 	app.Get("/:actor/catalog", routes.ActorCatalog)
-	app.Post("/:actor/inbox", routes.ActorInbox)
+	app.Post("/:actor/inbox", routes.FederationLogger, routes.ActorInbox)
 	app.Get("/:actor/outbox", routes.GetActorOutbox)
 	app.Post("/:actor/outbox", routes.PostActorOutbox)
 	app.Get("/:actor/following", routes.ActorFollowing)
@@ -175,6 +191,10 @@ func Init() {
 		config.Log.Println(err)
 	}
 
+	if err = activitypub.CreateMissingEd25519Pems(); err != nil {
+		config.Log.Println(err)
+	}
+
 	if actor, err = activitypub.GetActorFromDB(config.Domain); err != nil {
 		config.Log.Println(err)
 	}
@@ -201,5 +221,13 @@ func Init() {
 
 	go util.MakeCaptchas(100)
 
-	go db.CheckInactive()
+	go activitypub.StartInstanceHealth()
+
+	go activitypub.StartDeliveryWorkers()
+
+	go activitypub.StartInboxWorkers()
+
+	go activitypub.StartBackfillWorker()
+
+	go activitypub.StartPruneSeen()
 }
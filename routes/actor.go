@@ -2,7 +2,6 @@ package routes
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"io"
 	"mime/multipart"
@@ -24,158 +23,97 @@ func ActorInbox(ctx *fiber.Ctx) error {
 		return ctx.SendStatus(404)
 	}
 
-	activity, err := activitypub.GetActivityFromJson(ctx)
+	activity, err := GetVerifiedInboxActivity(ctx)
 
-	if err != nil {
+	if err == errInstanceNotAllowed {
+		SetFederationLogResult(ctx, "blocked", err.Error())
+		return ctx.SendStatus(403)
+	} else if errors.Is(err, activitypub.ErrNoActivityStreams) {
+		SetFederationLogResult(ctx, "ignored", err.Error())
+		return ctx.SendStatus(202)
+	} else if errors.As(err, new(*activitypub.ParseError)) {
+		SetFederationLogResult(ctx, "malformed", err.Error())
+		return ctx.SendStatus(400)
+	} else if err != nil {
 		return util.MakeError(err, "ActorInbox")
 	}
-	if activity.Actor == nil || activity.Actor.PublicKey == nil || activity.Actor.PublicKey.Id == "" {
-		nActor, err := activitypub.FingerActor("")
-		if activity.Actor != nil {
-			if activity.Actor.Id != "" {
-				nActor, err = activitypub.FingerActor(activity.Actor.Id)
-			}
-		}
-		if err != nil {
-			return util.MakeError(err, "ActorInbox")
-		}
-
-		activity.Actor = &nActor
-	}
-	if activity.Actor == nil || activity.Actor.PublicKey == nil || activity.Actor.PublicKey.Id == "" {
-		return util.MakeError(errors.New("missing actor or public key for signature verification"), "ActorInbox")
-	}
 
 	if !activity.Actor.VerifyHeaderSignature(ctx) {
+		SetFederationLogResult(ctx, "invalid", "signature did not verify")
 		response := activity.Reject()
 		return response.MakeRequestInbox()
 	}
 
-	switch activity.Type {
-	case "Create":
-		for _, e := range activity.To {
-			actor := activitypub.Actor{Id: e}
-			if err := actor.ProcessInboxCreate(activity); err != nil {
-				return util.MakeError(err, "ActorInbox")
-			}
+	return util.MakeError(QueueVerifiedActivity(ctx, activity), "ActorInbox")
+}
 
-			if err := actor.SendToFollowers(activity); err != nil {
-				return util.MakeError(err, "ActorInbox")
-			}
-		}
+var errInstanceNotAllowed = errors.New("instance is not federated with")
 
-		for _, e := range activity.Cc {
-			actor := activitypub.Actor{Id: e}
-			if err := actor.ProcessInboxCreate(activity); err != nil {
-				return util.MakeError(err, "ActorInbox")
-			}
-		}
+// QueueVerifiedActivity hands an activity whose signature checked out to the
+// inbox workers and answers 202. A replayed request is refused, a repeated
+// delivery of an activity is only acknowledged.
+func QueueVerifiedActivity(ctx *fiber.Ctx, activity activitypub.Activity) error {
+	if replayed, err := activitypub.IsReplayedSignature(ctx); err != nil {
+		return util.MakeError(err, "QueueVerifiedActivity")
+	} else if replayed {
+		SetFederationLogResult(ctx, "replayed", "signature was already used")
+		return ctx.SendStatus(401)
+	}
 
-	case "Delete":
-		for _, e := range activity.To {
-			actor, err := activitypub.GetActorFromDB(e)
-			if err != nil {
-				continue // try again
-				// return util.MakeError(err, "ActorInbox")
-			}
+	SetFederationLogResult(ctx, "valid", "")
 
-			if actor.Id != "" && actor.Id != config.Domain {
-				if activity.Object.Replies != nil {
-					for _, k := range activity.Object.Replies.OrderedItems {
-						if err := k.Tombstone(); err != nil {
-							return util.MakeError(err, "ActorInbox")
-						}
-					}
-				}
+	if err := activitypub.RecordInbound(activity.Actor.Id); err != nil {
+		config.Log.Println(err)
+	}
 
-				if err := activity.Object.Tombstone(); err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
+	if seen, err := activity.MarkSeen(); err != nil {
+		return util.MakeError(err, "QueueVerifiedActivity")
+	} else if seen {
+		SetFederationLogResult(ctx, "valid", "duplicate delivery")
+		return ctx.SendStatus(202)
+	}
 
-				if err := actor.UnArchiveLast(); err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
-				break
-			}
+	if err := activitypub.EnqueueInbox(activity); err != nil {
+		if err := activity.UnmarkSeen(); err != nil {
+			config.Log.Println(err)
 		}
 
-	case "Follow":
-		for _, e := range activity.To {
-			if _, err := activitypub.GetActorFromDB(e); err == nil {
-				response := activity.AcceptFollow()
-				response, err := response.SetActorFollower()
-
-				if err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
-
-				if err := response.MakeRequestInbox(); err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
-
-				alreadyFollowing, err := response.Actor.IsAlreadyFollowing(response.Object.Id)
-
-				if err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
-
-				objActor, err := activitypub.FingerActor(response.Object.Actor)
-
-				if err != nil || objActor.Id == "" {
-					return util.MakeError(err, "ActorInbox")
-				}
-
-				reqActivity := activitypub.Activity{Id: objActor.Following}
-				remoteActorFollowingCol, err := reqActivity.GetCollection()
-
-				if err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
-
-				alreadyFollow := false
+		return util.MakeError(err, "QueueVerifiedActivity")
+	}
 
-				for _, e := range remoteActorFollowingCol.Items {
-					if e.Id == response.Actor.Id {
-						alreadyFollowing = true
-					}
-				}
+	return ctx.SendStatus(202)
+}
 
-				autoSub, err := response.Actor.GetAutoSubscribe()
+// GetVerifiedInboxActivity parses a posted activity and resolves its actor so
+// the signature can be checked against the actor's published key.
+func GetVerifiedInboxActivity(ctx *fiber.Ctx) (activitypub.Activity, error) {
+	activity, err := activitypub.GetActivityFromJson(ctx)
 
-				if err != nil {
-					return util.MakeError(err, "ActorInbox")
-				}
+	if errors.As(err, new(*activitypub.ParseError)) {
+		return activity, err
+	} else if err != nil {
+		return activity, util.MakeError(err, "GetVerifiedInboxActivity")
+	}
 
-				if autoSub && !alreadyFollow && alreadyFollowing {
-					followActivity, err := response.Actor.MakeFollowActivity(response.Object.Actor)
+	if activity.Actor != nil && !util.IsInstanceAllowed(activity.Actor.Id) {
+		return activity, errInstanceNotAllowed
+	}
 
-					if err != nil {
-						return util.MakeError(err, "ActorInbox")
-					}
+	if activity.Actor == nil || activity.Actor.Id == "" {
+		return activity, util.MakeError(errors.New("missing actor for signature verification"), "GetVerifiedInboxActivity")
+	}
 
-					if err := followActivity.MakeRequestOutbox(); err != nil {
-						return util.MakeError(err, "ActorInbox")
-					}
-				}
-			} else if err != nil {
-				return util.MakeError(err, "ActorInbox")
-			} else {
-				config.Log.Println("follow request for rejected")
-				response := activity.Reject()
-				return response.MakeRequestInbox()
-			}
-		}
+	// An actor embedded in the body is only a claim, the signature has to be
+	// checked against the document its own instance publishes.
+	nActor, err := activitypub.GetPublishedActor(activity.Actor.Id)
 
-	case "Reject":
-		if activity.Object.Object.Type == "Follow" {
-			config.Log.Println("follow rejected")
-			if _, err := activity.SetActorFollowing(); err != nil {
-				return util.MakeError(err, "ActorInbox")
-			}
-		}
+	if err != nil {
+		return activity, util.MakeError(err, "GetVerifiedInboxActivity")
 	}
 
-	return nil
+	activity.Actor = &nActor
+
+	return activity, nil
 }
 
 func PostActorOutbox(ctx *fiber.Ctx) error {
@@ -186,6 +124,10 @@ func PostActorOutbox(ctx *fiber.Ctx) error {
 	}
 
 	if activitypub.AcceptActivity(ctx.Get("Accept")) {
+		if !HasAuthorizedFetch(ctx) {
+			return ctx.SendStatus(401)
+		}
+
 		return actor.GetOutbox(ctx)
 	}
 
@@ -193,11 +135,19 @@ func PostActorOutbox(ctx *fiber.Ctx) error {
 }
 
 func ActorFollowing(ctx *fiber.Ctx) error {
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
+
 	actor, _ := activitypub.GetActorFromDB(config.Domain + "/" + ctx.Params("actor"))
 	return actor.GetFollowingResp(ctx)
 }
 
 func ActorFollowers(ctx *fiber.Ctx) error {
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
+
 	actor, _ := activitypub.GetActorFromDB(config.Domain + "/" + ctx.Params("actor"))
 	return actor.GetFollowersResp(ctx)
 }
@@ -532,6 +482,10 @@ func ActorPost(ctx *fiber.Ctx) error {
 	data.Key = config.Key
 	data.Boards = activitypub.Boards
 
+	if data.Crossposts, err = actor.GetCrosspostMap(); err != nil {
+		return Send500(ctx, "Failed to get cross-posts", util.MakeError(err, "ActorPost"))
+	}
+
 	data.Title = "/" + data.Board.PrefName + "/ - " + data.PostId
 
 	if len(data.Posts) > 0 {
@@ -602,6 +556,10 @@ func ActorCatalog(ctx *fiber.Ctx) error {
 	data.Title = "/" + data.Board.PrefName + "/ - Catalog"
 
 	data.Boards = activitypub.Boards
+
+	if data.Crossposts, err = actor.GetCrosspostMap(); err != nil {
+		return Send500(ctx, "Failed to get cross-posts", util.MakeError(err, "ActorCatalog"))
+	}
 	data.Posts = collection.OrderedItems
 
 	data.Meta.Description = data.Board.Summary
@@ -686,6 +644,10 @@ func ActorPosts(ctx *fiber.Ctx) error {
 	data.Key = config.Key
 
 	data.Boards = activitypub.Boards
+
+	if data.Crossposts, err = actor.GetCrosspostMap(); err != nil {
+		return Send500(ctx, "Failed to get cross-posts", util.MakeError(err, "ActorPosts"))
+	}
 	data.Posts = collection.OrderedItems
 
 	data.Pages = pages
@@ -827,19 +789,11 @@ func ActorList(ctx *fiber.Ctx) error {
 }
 
 func GetActorOutbox(ctx *fiber.Ctx) error {
-	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/")
-
-	collection, _ := actor.GetCollection()
-	collection.AtContext.Context = "https://www.w3.org/ns/activitystreams"
-	collection.Actor = &actor
-
-	collection.TotalItems, _ = actor.GetPostTotal()
-	collection.TotalImgs, _ = actor.GetImgTotal()
-
-	enc, _ := json.Marshal(collection)
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
 
-	ctx.Response().Header.Add("Content-Type", config.ActivityStreams)
-	_, err := ctx.Write(enc)
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/")
 
-	return err
+	return actor.GetOutbox(ctx)
 }
@@ -1,11 +1,13 @@
 package routes
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/anomalous69/fchannel/activitypub"
@@ -33,6 +35,12 @@ func calculateBoardOptionsMask(ctx *fiber.Ctx) int {
 	if ctx.FormValue("option_readonly") == "1" {
 		optionsMask |= activitypub.OptionReadOnly
 	}
+	if ctx.FormValue("option_userreplies") == "1" {
+		optionsMask |= activitypub.OptionUserReplies
+	}
+	if ctx.FormValue("option_relay") == "1" {
+		optionsMask |= activitypub.OptionRelay
+	}
 	return optionsMask
 }
 
@@ -127,6 +135,42 @@ func AdminIndex(ctx *fiber.Ctx) error {
 
 	adminData.PostBlacklist, _ = util.GetRegexBlacklist()
 
+	adminData.DeliveryCounts, _ = activitypub.GetDeliveryCounts()
+
+	for _, status := range []string{activitypub.DeliveryDead, activitypub.DeliveryFailed, activitypub.DeliveryPending} {
+		deliveries, _ := activitypub.GetDeliveries(status, 25)
+		adminData.Deliveries = append(adminData.Deliveries, deliveries...)
+	}
+
+	adminData.InboxCounts, _ = activitypub.GetInboxCounts()
+
+	for _, status := range []string{activitypub.InboxDead, activitypub.InboxFailed} {
+		items, _ := activitypub.GetInboxItems(status, 25)
+		adminData.InboxItems = append(adminData.InboxItems, items...)
+	}
+
+	adminData.InstanceHealth, _ = activitypub.GetInstanceHealth()
+	adminData.InstanceBlocks, _ = util.GetInstanceBlocks()
+	adminData.InstanceAllows, _ = util.GetInstanceAllows()
+	adminData.Federation = config.Federation
+
+	adminData.Relays, _ = activitypub.GetRelays()
+	adminData.RelayBoards, _ = activitypub.GetRelayBoards(50)
+
+	adminData.PolicyRules, _ = activitypub.GetPolicyRules()
+	adminData.HeldActivities, _ = activitypub.GetHeldActivities()
+
+	for k := range activitypub.PolicyTypes {
+		adminData.PolicyTypes = append(adminData.PolicyTypes, k)
+	}
+
+	sort.Strings(adminData.PolicyTypes)
+
+	adminData.LogInstance = ctx.Query("instance")
+	adminData.LogActor = ctx.Query("actor")
+	adminData.LogType = ctx.Query("type")
+	adminData.FederationLog, _ = activitypub.GetFederationLog(adminData.LogInstance, adminData.LogActor, adminData.LogType, 50)
+
 	adminData.Meta.Description = adminData.Title
 	adminData.Meta.Url = adminData.Board.Actor.Id
 	adminData.Meta.Title = adminData.Title
@@ -147,6 +191,27 @@ func AdminFollow(ctx *fiber.Ctx) error {
 	actorId := ctx.FormValue("actor")
 
 	actor := activitypub.Actor{Id: actorId}
+
+	// Following an actor again unfollows it, tell the other side with an Undo
+	// rather than a second Follow.
+	if following, _ := actor.IsFollowing(follow); following {
+		undoActivity, err := actor.MakeUndoFollowActivity(follow)
+
+		if err != nil {
+			return util.MakeError(err, "AdminFollow")
+		}
+
+		if err := undoActivity.MakeRequestOutbox(); err != nil {
+			return util.MakeError(err, "AdminFollow")
+		}
+
+		return adminFollowRedirect(ctx)
+	}
+
+	if !util.IsInstanceAllowed(follow) {
+		return Send403(ctx, "Instance is not on the federation allowlist or is blocked")
+	}
+
 	followActivity, _ := actor.MakeFollowActivity(follow)
 
 	objActor := activitypub.Actor{Id: followActivity.Object.Actor}
@@ -162,8 +227,12 @@ func AdminFollow(ctx *fiber.Ctx) error {
 		}
 	}
 
+	return adminFollowRedirect(ctx)
+}
+
+func adminFollowRedirect(ctx *fiber.Ctx) error {
 	var redirect string
-	actor, _ = activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
 
 	if actor.PreferredUsername != "main" {
 		redirect = actor.PreferredUsername
@@ -302,6 +371,10 @@ func AdminActorIndex(ctx *fiber.Ctx) error {
 
 	data.RecentPosts, _ = actor.GetRecentPosts()
 
+	data.Backfills, _ = actor.GetBackfills()
+
+	data.Crossposts, _ = actor.GetCrossposts()
+
 	if cookie := ctx.Cookies("theme"); cookie != "" {
 		data.ThemeCookie = cookie
 	}
@@ -378,6 +451,36 @@ func AdminEditSummary(ctx *fiber.Ctx) error {
 
 }
 
+func AdminRotateKey(ctx *fiber.Ctx) error {
+	id, pass := util.GetPasswordFromSession(ctx)
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
+
+	if actor.Id == "" {
+		actor, _ = activitypub.GetActorByNameFromDB(config.Domain)
+	}
+
+	hasAuth, _type := util.HasAuth(pass, actor.Id)
+
+	if !hasAuth || _type != "admin" || (id != actor.Id && id != config.Domain) {
+		return util.MakeError(errors.New("Error"), "AdminRotateKey")
+	}
+
+	if _, err := activitypub.RotatePem(actor); err != nil {
+		return Send500(ctx, "Failed to rotate key", util.MakeError(err, "AdminRotateKey"))
+	}
+
+	if err := actor.UpdateActorRequest(); err != nil {
+		return Send500(ctx, "Rotated key but failed to notify other instances", util.MakeError(err, "AdminRotateKey"))
+	}
+
+	var redirect string
+	if actor.PreferredUsername != "main" {
+		redirect = actor.PreferredUsername
+	}
+
+	return ctx.Redirect("/"+config.Key+"/"+redirect+"#boardsettings", http.StatusSeeOther)
+}
+
 func AdminSetBoardType(ctx *fiber.Ctx) error {
 	id, pass := util.GetPasswordFromSession(ctx)
 	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
@@ -468,3 +571,358 @@ func AdminSetBoardOptions(ctx *fiber.Ctx) error {
 
 	return ctx.Redirect("/"+config.Key+"/"+redirect, http.StatusSeeOther)
 }
+
+// AdminCrosspost shows a thread from another board on this one, ?remove=
+// takes it off again.
+func AdminCrosspost(ctx *fiber.Ctx) error {
+	id, pass := util.GetPasswordFromSession(ctx)
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
+
+	if actor.Id == "" {
+		return Send404(ctx, "Board not found")
+	}
+
+	if hasAuth, _ := util.HasAuth(pass, actor.Id); !hasAuth || (id != actor.Id && id != config.Domain) {
+		return Send403(ctx, "You are not authorized to cross-post to this board")
+	}
+
+	if ctx.Method() == "GET" {
+		if remove := ctx.Query("remove"); remove != "" {
+			if err := actor.RemoveCrosspost(remove); err != nil {
+				return Send500(ctx, "Failed to remove cross-post", util.MakeError(err, "AdminCrosspost"))
+			}
+		}
+	} else if thread := strings.TrimSpace(ctx.FormValue("thread")); thread != "" {
+		if err := actor.Crosspost(thread); err != nil {
+			return Send400(ctx, "Failed to cross-post: "+err.Error(), util.MakeError(err, "AdminCrosspost"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"/"+actor.PreferredUsername+"#crosspost", http.StatusSeeOther)
+}
+
+// AdminExport downloads the board's export, which AdminImport turns back into
+// a board on another instance.
+func AdminExport(ctx *fiber.Ctx) error {
+	id, pass := util.GetPasswordFromSession(ctx)
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
+
+	if actor.Id == "" || actor.PreferredUsername == "main" {
+		return Send404(ctx, "Board not found")
+	}
+
+	if hasAuth, _type := util.HasAuth(pass, actor.Id); !hasAuth || _type != "admin" || (id != actor.Id && id != config.Domain) {
+		return Send403(ctx, "You are not authorized to export this board")
+	}
+
+	export, err := actor.Export()
+
+	if err != nil {
+		return Send500(ctx, "Failed to export board", util.MakeError(err, "AdminExport"))
+	}
+
+	ctx.Set("Content-Disposition", `attachment; filename="`+actor.PreferredUsername+`-export.json"`)
+
+	return ctx.JSON(export)
+}
+
+// AdminMove sets the ids the board was known by elsewhere, or moves it to a
+// board that lists this one among its own.
+func AdminMove(ctx *fiber.Ctx) error {
+	id, pass := util.GetPasswordFromSession(ctx)
+	actor, _ := activitypub.GetActorFromPath(ctx.Path(), "/"+config.Key+"/")
+
+	if actor.Id == "" || actor.PreferredUsername == "main" {
+		return Send404(ctx, "Board not found")
+	}
+
+	if hasAuth, _type := util.HasAuth(pass, actor.Id); !hasAuth || _type != "admin" || (id != actor.Id && id != config.Domain) {
+		return Send403(ctx, "You are not authorized to move this board")
+	}
+
+	if target := strings.TrimSpace(ctx.FormValue("target")); target != "" {
+		if err := actor.Move(target); err != nil {
+			return Send400(ctx, "Failed to move board: "+err.Error(), util.MakeError(err, "AdminMove"))
+		}
+	} else if err := actor.SetAlsoKnownAs(strings.Fields(ctx.FormValue("alsoknownas"))); err != nil {
+		return Send500(ctx, "Failed to save alsoKnownAs", util.MakeError(err, "AdminMove"))
+	}
+
+	return ctx.Redirect("/"+config.Key+"/"+actor.PreferredUsername+"#move", http.StatusSeeOther)
+}
+
+// AdminImport creates a board from an export made on another instance.
+func AdminImport(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminImport"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to import boards")
+	}
+
+	header, err := ctx.FormFile("export")
+
+	if err != nil {
+		return Send400(ctx, "No export file was provided")
+	}
+
+	file, err := header.Open()
+
+	if err != nil {
+		return Send500(ctx, "Failed to read export", util.MakeError(err, "AdminImport"))
+	}
+
+	defer file.Close()
+
+	var export activitypub.BoardExport
+
+	if err := json.NewDecoder(file).Decode(&export); err != nil {
+		return Send400(ctx, "Export is not valid JSON")
+	}
+
+	board, err := db.ImportBoard(export, strings.TrimSpace(ctx.FormValue("name")))
+
+	if err != nil {
+		return Send400(ctx, "Failed to import board: "+err.Error(), util.MakeError(err, "AdminImport"))
+	}
+
+	return ctx.Redirect("/"+config.Key+"/"+board.PreferredUsername+"#move", http.StatusSeeOther)
+}
+
+func AdminDelivery(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminDelivery"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to manage deliveries")
+	}
+
+	if retry := ctx.Query("retry"); retry != "" {
+		if id, convErr := strconv.Atoi(retry); convErr == nil {
+			err = activitypub.RetryDelivery(id)
+		} else if retry == activitypub.DeliveryFailed || retry == activitypub.DeliveryDead {
+			err = activitypub.RetryDeliveries(retry)
+		}
+
+		if err != nil {
+			return Send500(ctx, "Failed to retry delivery", util.MakeError(err, "AdminDelivery"))
+		}
+	}
+
+	if purge := ctx.Query("purge"); purge != "" {
+		if id, convErr := strconv.Atoi(purge); convErr == nil {
+			err = activitypub.DeleteDelivery(id)
+		} else if purge == activitypub.DeliveryDelivered || purge == activitypub.DeliveryDead {
+			err = activitypub.PurgeDeliveries(purge)
+		}
+
+		if err != nil {
+			return Send500(ctx, "Failed to purge delivery", util.MakeError(err, "AdminDelivery"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#delivery", http.StatusSeeOther)
+}
+
+func AdminInstanceBlock(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminInstanceBlock"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to modify blocked instances")
+	}
+
+	if ctx.Method() == "GET" {
+		if id := ctx.Query("remove"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := util.DeleteInstanceBlock(i); err != nil {
+				return Send500(ctx, "Failed to remove instance block", util.MakeError(err, "AdminInstanceBlock"))
+			}
+		}
+	} else {
+		domain := ctx.FormValue("domain")
+
+		if domain == "" {
+			return ctx.Redirect("/"+config.Key+"#instanceblock", http.StatusSeeOther)
+		}
+
+		if err := util.WriteInstanceBlock(domain, ctx.FormValue("reason")); err != nil {
+			return Send400(ctx, "Failed to block instance", util.MakeError(err, "AdminInstanceBlock"))
+		}
+
+		if err := activitypub.BlockInstance(ctx.FormValue("federate") == "1"); err != nil {
+			return Send500(ctx, "Failed to remove follows with blocked instance", util.MakeError(err, "AdminInstanceBlock"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#instanceblock", http.StatusSeeOther)
+}
+
+// AdminRelay subscribes the instance to a relay or unsubscribes it.
+func AdminRelay(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminRelay"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to manage relays")
+	}
+
+	if ctx.Method() == "GET" {
+		if id := ctx.Query("remove"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := activitypub.UnsubscribeRelay(i); err != nil {
+				return Send500(ctx, "Failed to unsubscribe from relay", util.MakeError(err, "AdminRelay"))
+			}
+		}
+	} else if url := ctx.FormValue("url"); url != "" {
+		if err := activitypub.SubscribeRelay(url); err != nil {
+			return Send400(ctx, "Failed to subscribe to relay: "+err.Error(), util.MakeError(err, "AdminRelay"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#relays", http.StatusSeeOther)
+}
+
+func AdminInstanceAllow(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminInstanceAllow"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to modify allowed instances")
+	}
+
+	if ctx.Method() == "GET" {
+		if id := ctx.Query("remove"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := util.DeleteInstanceAllow(i); err != nil {
+				return Send500(ctx, "Failed to remove allowed instance", util.MakeError(err, "AdminInstanceAllow"))
+			}
+		}
+	} else if domain := ctx.FormValue("domain"); domain != "" {
+		if err := util.WriteInstanceAllow(domain); err != nil {
+			return Send400(ctx, "Failed to allow instance", util.MakeError(err, "AdminInstanceAllow"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#instanceallow", http.StatusSeeOther)
+}
+
+func AdminFederationPolicy(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminFederationPolicy"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to modify federation policies")
+	}
+
+	if ctx.Method() == "GET" {
+		if id := ctx.Query("remove"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := activitypub.DeletePolicyRule(i); err != nil {
+				return Send500(ctx, "Failed to remove policy", util.MakeError(err, "AdminFederationPolicy"))
+			}
+		}
+
+		if id := ctx.Query("release"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := activitypub.ReleaseHeldActivity(i); err != nil {
+				return Send500(ctx, "Failed to release activity", util.MakeError(err, "AdminFederationPolicy"))
+			}
+		}
+
+		if id := ctx.Query("discard"); id != "" {
+			i, _ := strconv.Atoi(id)
+			if err := activitypub.DeleteHeldActivity(i); err != nil {
+				return Send500(ctx, "Failed to discard activity", util.MakeError(err, "AdminFederationPolicy"))
+			}
+		}
+	} else if scope := ctx.FormValue("scope"); scope != "" {
+		if err := activitypub.WritePolicyRule(scope, ctx.FormValue("policy"), ctx.FormValue("value")); err != nil {
+			return Send400(ctx, "Failed to add policy: "+err.Error())
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#federationpolicy", http.StatusSeeOther)
+}
+
+func AdminInboxQueue(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminInboxQueue"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to manage the inbox queue")
+	}
+
+	if retry := ctx.Query("retry"); retry != "" {
+		if id, convErr := strconv.Atoi(retry); convErr == nil {
+			err = activitypub.RetryInboxItem(id)
+		} else if retry == activitypub.InboxFailed || retry == activitypub.InboxDead {
+			err = activitypub.RetryInboxItems(retry)
+		}
+
+		if err != nil {
+			return Send500(ctx, "Failed to retry activity", util.MakeError(err, "AdminInboxQueue"))
+		}
+	}
+
+	if purge := ctx.Query("purge"); purge != "" {
+		if id, convErr := strconv.Atoi(purge); convErr == nil {
+			err = activitypub.DeleteInboxItem(id)
+		} else if purge == activitypub.InboxProcessed || purge == activitypub.InboxDead {
+			err = activitypub.PurgeInboxItems(purge)
+		}
+
+		if err != nil {
+			return Send500(ctx, "Failed to purge activity", util.MakeError(err, "AdminInboxQueue"))
+		}
+	}
+
+	return ctx.Redirect("/"+config.Key+"#inboxqueue", http.StatusSeeOther)
+}
+
+func AdminInstanceHealth(ctx *fiber.Ctx) error {
+	actor, err := activitypub.GetActorFromDB(config.Domain)
+
+	if err != nil {
+		return Send400(ctx, "Board does not exist or server encountered issue with database", util.MakeError(err, "AdminInstanceHealth"))
+	}
+
+	if has := actor.HasValidation(ctx); !has {
+		return Send403(ctx, "You are not authorized to manage instances")
+	}
+
+	if instance := ctx.Query("pause"); instance != "" {
+		err = activitypub.SetInstancePaused(instance, true)
+	} else if instance := ctx.Query("resume"); instance != "" {
+		err = activitypub.SetInstancePaused(instance, false)
+	} else if instance := ctx.Query("drop"); instance != "" {
+		err = activitypub.DropInstanceFollowers(instance)
+	}
+
+	if err != nil {
+		return Send500(ctx, "Failed to update instance", util.MakeError(err, "AdminInstanceHealth"))
+	}
+
+	return ctx.Redirect("/"+config.Key+"#instancehealth", http.StatusSeeOther)
+}
@@ -20,6 +20,16 @@ func Media(ctx *fiber.Ctx) error {
 }
 
 func RouteImages(ctx *fiber.Ctx, media string) error {
+	if !util.IsInstanceAllowed(config.MediaHashs[media]) {
+		fileBytes, err := os.ReadFile("./static/notfound.png")
+		if err != nil {
+			return util.MakeError(err, "RouteImages")
+		}
+
+		_, err = ctx.Write(fileBytes)
+		return util.MakeError(err, "RouteImages")
+	}
+
 	req, err := http.NewRequest("GET", config.MediaHashs[media], nil)
 	if err != nil {
 		return util.MakeError(err, "RouteImages")
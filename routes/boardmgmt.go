@@ -527,15 +527,6 @@ func ReportPost(ctx *fiber.Ctx) error {
 		return ctx.Redirect("/"+config.Key+"/"+board, http.StatusSeeOther)
 	}
 
-	if local, _ := obj.IsLocal(); !local {
-		if err := db.CreateLocalReport(id, board, reason); err != nil {
-			config.Log.Println(err)
-			return Send404(ctx, "", err) //TODO: FILL IN
-		}
-
-		return ctx.Redirect("/"+board+"/"+util.RemoteShort(obj.Id), http.StatusSeeOther)
-	}
-
 	var captcha = ctx.FormValue("captchaCode") + ":" + ctx.FormValue("captcha")
 
 	if len(reason) > 100 {
@@ -550,6 +541,23 @@ func ReportPost(ctx *fiber.Ctx) error {
 		return Send403(ctx, "Invalid captcha")
 	}
 
+	if local, _ := obj.IsLocal(); !local {
+		if err := db.CreateLocalReport(id, board, reason); err != nil {
+			config.Log.Println(err)
+			return Send404(ctx, "", err) //TODO: FILL IN
+		}
+
+		// The reporter can pass it on to the moderators of the board the
+		// post is from
+		if ctx.FormValue("forward") == "1" {
+			if err := actor.Flag(id, reason); err != nil {
+				config.Log.Println(err)
+			}
+		}
+
+		return ctx.Redirect("/"+board+"/"+util.RemoteShort(obj.Id), http.StatusSeeOther)
+	}
+
 	if err := db.CreateLocalReport(obj.Id, board, reason); err != nil {
 		config.Log.Println(err)
 		return Send404(ctx, "") //TODO: FILL IN
@@ -645,7 +653,9 @@ func ReportGet(ctx *fiber.Ctx) error {
 		data.Referer = ctx.Get("referer")
 	}
 
-	return ctx.Render("report", fiber.Map{"page": data}, "layouts/main")
+	local, _ := activitypub.ObjectBase{Id: data.Board.InReplyTo}.IsLocal()
+
+	return ctx.Render("report", fiber.Map{"page": data, "remote": !local}, "layouts/main")
 }
 
 func Sticky(ctx *fiber.Ctx) error {
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anomalous69/fchannel/activitypub"
+	"github.com/anomalous69/fchannel/config"
+	"github.com/anomalous69/fchannel/db"
+	"github.com/anomalous69/fchannel/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+func EditGet(ctx *fiber.Ctx) error {
+	var ban db.Ban
+
+	ban.IP, _, _, _, _ = db.IsIPBanned(ctx.IP())
+	if len(ban.IP) > 1 {
+		return ctx.Redirect(ctx.BaseURL()+"/banned", 301)
+	}
+
+	actor, err := activitypub.GetActorByNameFromDB(ctx.Query("board"))
+
+	if err != nil {
+		return Send404(ctx, "Board not found", util.MakeError(err, "EditGet"))
+	}
+
+	obj := activitypub.ObjectBase{Id: ctx.Query("id")}
+	post, err := obj.GetFromPath()
+
+	if err != nil || post.Actor != actor.Id || post.Type != "Note" {
+		return Send404(ctx, "Post not found", util.MakeError(err, "EditGet"))
+	}
+
+	// Stored posts have < escaped, show the comment as it was typed
+	post.Content = strings.ReplaceAll(post.Content, "&lt;", "<")
+
+	var data PageData
+	data.Board.Actor = actor
+	data.Board.Name = actor.Name
+	data.Board.PrefName = actor.PreferredUsername
+	data.Board.Summary = actor.Summary
+	data.Board.InReplyTo = post.Id
+	data.Board.Restricted = actor.Restricted
+	data.Board.BoardType = actor.BoardType
+	data.Board.ModCred, _ = util.GetPasswordFromSession(ctx)
+	data.Board.Domain = config.Domain
+	data.Posts = append(data.Posts, post)
+
+	data.Meta.Description = data.Board.Summary
+	data.Meta.Url = data.Board.Actor.Id
+	data.Meta.Title = data.Title
+
+	data.Instance, _ = activitypub.GetActorFromDB(config.Domain)
+
+	data.Themes = &config.Themes
+	data.ThemeCookie = GetThemeCookie(ctx)
+
+	data.ServerVersion = config.Version
+
+	data.Key = config.Key
+	data.Boards = activitypub.Boards
+
+	data.Referer = config.Domain + "/" + actor.PreferredUsername
+	if strings.Contains(ctx.Get("referer"), config.Domain+"/"+actor.PreferredUsername) && !strings.Contains(ctx.Get("referer"), "edit") {
+		data.Referer = ctx.Get("referer")
+	}
+
+	return ctx.Render("edit", fiber.Map{"page": data}, "layouts/main")
+}
+
+// EditPost changes the subject and comment of a local post. Moderators of the
+// board can edit any post, posters need the deletion password they posted
+// with and only have until maxpostedit runs out.
+func EditPost(ctx *fiber.Ctx) error {
+	var ban db.Ban
+
+	ban.IP, _, _, _, _ = db.IsIPBanned(ctx.IP())
+	if len(ban.IP) > 1 {
+		return ctx.Redirect(ctx.BaseURL()+"/banned", 301)
+	}
+
+	obj := activitypub.ObjectBase{Id: ctx.FormValue("id")}
+	post, err := obj.GetFromPath()
+
+	if err != nil {
+		return Send404(ctx, "Post not found", util.MakeError(err, "EditPost"))
+	}
+
+	if post.Type != "Note" {
+		return Send400(ctx, "Post was deleted and can not be edited")
+	}
+
+	_, auth := util.GetPasswordFromSession(ctx)
+
+	if has, _ := util.HasAuth(auth, post.Actor); !has {
+		pwd := ctx.FormValue("pwd")
+
+		if len(pwd) < 1 {
+			return Send400(ctx, "No password was provided")
+		}
+
+		var posted time.Time
+
+		query := `select posted from identify where id=$1 and password=crypt($2, password)`
+		if err := config.DB.QueryRow(query, post.Id, pwd).Scan(&posted); err == sql.ErrNoRows {
+			return Send403(ctx, "Incorrect password")
+		} else if err != nil {
+			return Send500(ctx, "Failed to edit post", util.MakeError(err, "EditPost"))
+		}
+
+		maxduration, _ := strconv.Atoi(config.MaxPostEdit)
+
+		if time.Now().UTC().Sub(posted.UTC()) > time.Duration(maxduration)*time.Second {
+			return Send403(ctx, "Post is too old to edit")
+		}
+	}
+
+	subject := ctx.FormValue("subject")
+	comment := ctx.FormValue("comment")
+
+	if is, _, regex := util.IsPostBlacklist(comment); is {
+		config.Log.Println("Blacklist post edit blocked \nRegex: " + regex + "\n" + comment)
+		return ctx.Redirect(ctx.BaseURL()+"/", 301)
+	}
+
+	if strings.TrimSpace(comment) == "" && subject == "" && len(post.Attachment) == 0 {
+		return Send400(ctx, "Subject or Comment is required")
+	}
+
+	if len(comment) > 4500 {
+		return Send400(ctx, "Comment is longer than 4500 characters")
+	}
+
+	if strings.Count(comment, "\r\n") > 50 || strings.Count(comment, "\n") > 50 || strings.Count(comment, "\r") > 50 {
+		return Send400(ctx, "Comment contains too many newlines")
+	}
+
+	if len(subject) > 100 {
+		return Send400(ctx, "Subject contains more than 100 characters")
+	}
+
+	post.Name = subject
+	post.Content = comment
+
+	if err := post.WriteEdit(time.Now().UTC()); err != nil {
+		return Send500(ctx, "Failed to edit post", util.MakeError(err, "EditPost"))
+	}
+
+	go func(obj activitypub.ObjectBase) {
+		if err := obj.UpdateRequest(); err != nil {
+			config.Log.Println(err)
+		}
+	}(post)
+
+	OP, _ := post.GetOP()
+
+	return ctx.Redirect(OP, http.StatusSeeOther)
+}
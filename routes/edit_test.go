@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/anomalous69/fchannel/activitypub"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+func TestEditRender(t *testing.T) {
+	const domain = "https://fchan.example"
+
+	tests := []struct {
+		name     string
+		modCred  string
+		password bool
+	}{
+		{name: "poster", modCred: "", password: true},
+		{name: "board mod", modCred: domain + "/g", password: false},
+		{name: "admin", modCred: domain, password: false},
+		{name: "mod of another board", modCred: domain + "/b", password: true},
+	}
+
+	engine := html.New("../views", ".html")
+	TemplateFunctions(engine)
+
+	if err := engine.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data PageData
+			data.Board.Actor = activitypub.Actor{Id: domain + "/g", Outbox: domain + "/g/outbox"}
+			data.Board.Name = "Technology"
+			data.Board.PrefName = "g"
+			data.Board.Domain = domain
+			data.Board.ModCred = tt.modCred
+			data.Posts = []activitypub.ObjectBase{{Id: domain + "/g/ABCD1234", Name: "subject", Content: "a comment"}}
+			data.Themes = &[]string{}
+
+			var buf bytes.Buffer
+
+			if err := engine.Render(&buf, "edit", fiber.Map{"page": data}, "layouts/main"); err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			body := buf.String()
+
+			if !strings.Contains(body, `id="edit-post"`) || !strings.Contains(body, "a comment") {
+				t.Error("edit form is not rendered")
+			}
+
+			if got := strings.Contains(body, `name="pwd"`); got != tt.password {
+				t.Errorf("password field shown = %v, want %v", got, tt.password)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"errors"
+
 	"github.com/anomalous69/fchannel/activitypub"
 	"github.com/anomalous69/fchannel/config"
 	"github.com/anomalous69/fchannel/db"
@@ -66,9 +68,37 @@ func Index(ctx *fiber.Ctx) error {
 	}, "layouts/main")
 }
 
+// Inbox is the shared inbox published in every actor's endpoints, remote
+// instances post here once for all of our boards they deliver to.
 func Inbox(ctx *fiber.Ctx) error {
-	// TODO main actor Inbox route
-	return ctx.SendString("main inbox")
+	activity, err := GetVerifiedInboxActivity(ctx)
+
+	if err == errInstanceNotAllowed {
+		SetFederationLogResult(ctx, "blocked", err.Error())
+		return ctx.SendStatus(403)
+	} else if errors.Is(err, activitypub.ErrNoActivityStreams) {
+		SetFederationLogResult(ctx, "ignored", err.Error())
+		return ctx.SendStatus(202)
+	} else if errors.As(err, new(*activitypub.ParseError)) {
+		SetFederationLogResult(ctx, "malformed", err.Error())
+		return ctx.SendStatus(400)
+	} else if err != nil {
+		return util.MakeError(err, "Inbox")
+	}
+
+	if !activity.Actor.VerifyHeaderSignature(ctx) {
+		SetFederationLogResult(ctx, "invalid", "signature did not verify")
+		response := activity.Reject()
+		return response.MakeRequestInbox()
+	}
+
+	activity, err = activity.AddLocalRecipients()
+
+	if err != nil {
+		return util.MakeError(err, "Inbox")
+	}
+
+	return util.MakeError(QueueVerifiedActivity(ctx, activity), "Inbox")
 }
 
 func Outbox(ctx *fiber.Ctx) error {
@@ -79,6 +109,10 @@ func Outbox(ctx *fiber.Ctx) error {
 	}
 
 	if activitypub.AcceptActivity(ctx.Get("Accept")) {
+		if !HasAuthorizedFetch(ctx) {
+			return ctx.SendStatus(401)
+		}
+
 		actor.GetOutbox(ctx)
 		return nil
 	}
@@ -87,11 +121,35 @@ func Outbox(ctx *fiber.Ctx) error {
 }
 
 func Following(ctx *fiber.Ctx) error {
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
+
 	actor, _ := activitypub.GetActorFromDB(config.Domain)
 	return actor.GetFollowingResp(ctx)
 }
 
 func Followers(ctx *fiber.Ctx) error {
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
+
 	actor, _ := activitypub.GetActorFromDB(config.Domain)
 	return actor.GetFollowersResp(ctx)
 }
+
+// SignedFetchFilter refuses signed GET requests made by instances we do not
+// federate with.
+func SignedFetchFilter(ctx *fiber.Ctx) error {
+	if ctx.Method() != "GET" {
+		return ctx.Next()
+	}
+
+	for _, e := range activitypub.GetSignatureKeyIds(ctx) {
+		if !util.IsInstanceAllowed(e) {
+			return ctx.SendStatus(403)
+		}
+	}
+
+	return ctx.Next()
+}
@@ -15,6 +15,7 @@ type PageData struct {
 	TotalPage     int
 	Boards        []activitypub.Board
 	Posts         []activitypub.ObjectBase
+	Crossposts    map[string]*activitypub.Crosspost
 	Key           string
 	PostId        string
 	Instance      activitypub.Actor
@@ -50,6 +51,33 @@ type AdminPage struct {
 	Meta          Meta
 	ServerVersion string
 
+	Deliveries     []activitypub.Delivery
+	DeliveryCounts map[string]int
+
+	InboxItems  []activitypub.InboxItem
+	InboxCounts map[string]int
+
+	InstanceHealth []activitypub.InstanceHealth
+	InstanceBlocks []util.InstanceBlock
+	InstanceAllows []util.InstanceAllow
+	Federation     string
+
+	Relays      []activitypub.Relay
+	RelayBoards []activitypub.RelayBoard
+
+	PolicyRules    []activitypub.PolicyRule
+	PolicyTypes    []string
+	HeldActivities []activitypub.HeldActivity
+
+	Backfills []activitypub.Backfill
+
+	Crossposts []activitypub.Crosspost
+
+	FederationLog []activitypub.FederationLogEntry
+	LogInstance   string
+	LogActor      string
+	LogType       string
+
 	Themes      *[]string
 	ThemeCookie string
 }
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"math/rand"
@@ -33,7 +34,95 @@ func GetThemeCookie(c *fiber.Ctx) string {
 	return "default"
 }
 
+// FederationLogger records inbox requests in the federation log once the
+// handler is done, handlers note the signature outcome with
+// SetFederationLogResult.
+func FederationLogger(ctx *fiber.Ctx) error {
+	if config.FederationLogSize < 1 {
+		return ctx.Next()
+	}
+
+	payload := append([]byte{}, ctx.Body()...)
+	headers := activitypub.FormatFederationLogHeaders(func(key string) string {
+		if key == "Host" {
+			return ctx.Hostname()
+		}
+
+		return ctx.Get(key)
+	})
+
+	handlerErr := ctx.Next()
+
+	entry := activitypub.NewFederationLogEntry(activitypub.FederationInbound, ctx.OriginalURL(), payload)
+	entry.Headers = headers
+	entry.Status = ctx.Response().StatusCode()
+
+	if verified, ok := ctx.Locals("fedlogVerified").(string); ok {
+		entry.Verified = verified
+	}
+
+	if note, ok := ctx.Locals("fedlogNote").(string); ok {
+		entry.Error = note
+	}
+
+	if handlerErr != nil {
+		entry.Status = fiber.StatusInternalServerError
+		if e, ok := handlerErr.(*fiber.Error); ok {
+			entry.Status = e.Code
+		}
+
+		entry.Error = handlerErr.Error()
+	}
+
+	if err := entry.Write(); err != nil {
+		config.Log.Println(err)
+	}
+
+	return handlerErr
+}
+
+func SetFederationLogResult(ctx *fiber.Ctx, verified string, note string) {
+	ctx.Locals("fedlogVerified", verified)
+	ctx.Locals("fedlogNote", note)
+}
+
+// HasAuthorizedFetch reports whether ActivityPub JSON may be served for the
+// request. With authorizedfetch on it has to be signed by an actor of an
+// instance we federate with.
+func HasAuthorizedFetch(ctx *fiber.Ctx) bool {
+	if !config.AuthorizedFetch {
+		return true
+	}
+
+	for _, e := range activitypub.GetSignatureKeyIds(ctx) {
+		owner, _, _ := strings.Cut(e, "#")
+
+		if owner == "" || !util.IsInstanceAllowed(owner) {
+			continue
+		}
+
+		var actor activitypub.Actor
+		var err error
+
+		if local, _ := (activitypub.Actor{Id: owner}).IsLocal(); local {
+			actor, err = activitypub.GetActorFromDB(owner)
+		} else {
+			actor, err = activitypub.GetActor(owner)
+		}
+
+		if err == nil && actor.VerifyHeaderSignature(ctx) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func GetActorPost(ctx *fiber.Ctx, path string) error {
+	if !HasAuthorizedFetch(ctx) {
+		return ctx.SendStatus(401)
+	}
+
 	obj := activitypub.ObjectBase{Id: config.Domain + path}
 	post, err := obj.GetFromPath()
 
@@ -41,7 +130,20 @@ func GetActorPost(ctx *fiber.Ctx, path string) error {
 		return Send404(ctx, "Post not found", util.MakeError(err, "GetActorPost"))
 	}
 
-	enc, err := json.MarshalIndent(post, "", "\t")
+	var enc []byte
+
+	if activitypub.IsLegacyAccept(ctx.Get("Accept")) {
+		enc, err = json.MarshalIndent(post, "", "\t")
+	} else {
+		var note activitypub.InteropNote
+
+		if note, err = activitypub.GetInteropNote(post.Id); err != nil {
+			return Send500(ctx, "Failed to get post", util.MakeError(err, "GetActorPost"))
+		}
+
+		enc, err = json.MarshalIndent(note, "", "\t")
+	}
+
 	if err != nil {
 		return Send500(ctx, "Failed to get post", util.MakeError(err, "GetActorPost"))
 	}
@@ -167,6 +269,10 @@ func ParseOutboxRequest(ctx *fiber.Ctx, actor activitypub.Actor) error {
 				if err := activity.MakeRequestInbox(); err != nil {
 					config.Log.Printf("ParseOutboxRequest MakeRequestInbox: %s", err)
 				}
+
+				if err := actor.PublishToRelays(activity); err != nil {
+					config.Log.Printf("ParseOutboxRequest PublishToRelays: %s", err)
+				}
 			}(nObj)
 
 			go func(obj activitypub.ObjectBase) {
@@ -206,7 +312,11 @@ func ParseOutboxRequest(ctx *fiber.Ctx, actor activitypub.Actor) error {
 		}
 	} else { // json request
 		activity, err := activitypub.GetActivityFromJson(ctx)
-		if err != nil {
+		if errors.Is(err, activitypub.ErrNoActivityStreams) {
+			return ctx.SendStatus(202)
+		} else if errors.As(err, new(*activitypub.ParseError)) {
+			return ctx.SendStatus(400)
+		} else if err != nil {
 			return util.MakeError(err, "ParseOutboxRequest")
 		}
 
@@ -241,16 +351,30 @@ func ParseOutboxRequest(ctx *fiber.Ctx, actor activitypub.Actor) error {
 					}
 				}
 
-				actor, _ := activitypub.GetActorFromDB(config.Domain)
-				activitypub.FollowingBoards, err = actor.GetFollowing()
-
-				if err != nil {
+				if err := activitypub.RefreshBoards(); err != nil {
 					return util.MakeError(err, "ParseOutboxRequest")
 				}
 
-				activitypub.Boards, err = activitypub.GetBoardCollection()
+			case "Undo":
+				validLocalActor := (activity.Actor.Id == actor.Id)
+
+				if validLocalActor && activity.Object.Type == "Follow" && activity.Object.Object != nil {
+					following := activitypub.Actor{Id: activity.Object.Object.Id}
 
-				if err != nil {
+					if err := actor.RemoveFollowing(following.Id); err != nil {
+						return util.MakeError(err, "ParseOutboxRequest")
+					}
+
+					if local, _ := following.IsLocal(); !local {
+						go following.DeleteCache()
+					}
+
+					if err := activity.MakeRequestInbox(); err != nil {
+						return util.MakeError(err, "ParseOutboxRequest")
+					}
+				}
+
+				if err := activitypub.RefreshBoards(); err != nil {
 					return util.MakeError(err, "ParseOutboxRequest")
 				}
 
@@ -400,6 +524,10 @@ func TemplateFunctions(engine *html.Engine) {
 	engine.AddFunc("isOnion", util.IsOnion)
 
 	engine.AddFunc("parseReplyLink", func(actorId string, op string, id string, content string) template.HTML {
+		if !util.IsInstanceAllowed(actorId) {
+			return template.HTML("<span class=\"replyLink\">&gt;&gt;" + util.ShortURL(actorId+"/outbox", id) + "</span>")
+		}
+
 		actor, _ := activitypub.FingerActor(actorId)
 		title := strings.ReplaceAll(db.ParseLinkTitle(actor.Id+"/", op, content), `/\&lt;`, ">")
 		link := "<a href=\"/" + actor.PreferredUsername + "/" + util.ShortURL(actor.Outbox, op) + "#" + util.ShortURL(actor.Outbox, id) + "\" title=\"" + title + "\" class=\"replyLink\">&gt;&gt;" + util.ShortURL(actor.Outbox, id) + "</a>"
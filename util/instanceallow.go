@@ -0,0 +1,124 @@
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+)
+
+const FederationOpen = "open"
+const FederationAllowlist = "allowlist"
+
+type InstanceAllow struct {
+	Id      int
+	Domain  string
+	Created time.Time
+}
+
+var allowedInstances []string
+var allowedInstancesLoaded bool
+var allowedInstancesMutex sync.RWMutex
+
+func GetInstanceAllows() ([]InstanceAllow, error) {
+	var list []InstanceAllow
+
+	query := `select id, domain, created from instanceallow order by domain`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return list, MakeError(err, "GetInstanceAllows")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var temp InstanceAllow
+
+		if err := rows.Scan(&temp.Id, &temp.Domain, &temp.Created); err != nil {
+			return list, MakeError(err, "GetInstanceAllows")
+		}
+
+		list = append(list, temp)
+	}
+
+	return list, nil
+}
+
+func WriteInstanceAllow(domain string) error {
+	domain = GetInstanceHost(domain)
+
+	if domain == "" || domain == GetInstanceHost(config.Domain) {
+		return nil
+	}
+
+	query := `insert into instanceallow (domain) values ($1) on conflict (domain) do nothing`
+	if _, err := config.DB.Exec(query, domain); err != nil {
+		return MakeError(err, "WriteInstanceAllow")
+	}
+
+	return MakeError(LoadInstanceAllows(), "WriteInstanceAllow")
+}
+
+func DeleteInstanceAllow(id int) error {
+	query := `delete from instanceallow where id=$1`
+	if _, err := config.DB.Exec(query, id); err != nil {
+		return MakeError(err, "DeleteInstanceAllow")
+	}
+
+	return MakeError(LoadInstanceAllows(), "DeleteInstanceAllow")
+}
+
+func LoadInstanceAllows() error {
+	list, err := GetInstanceAllows()
+
+	if err != nil {
+		return MakeError(err, "LoadInstanceAllows")
+	}
+
+	var domains []string
+	for _, e := range list {
+		domains = append(domains, e.Domain)
+	}
+
+	allowedInstancesMutex.Lock()
+	defer allowedInstancesMutex.Unlock()
+
+	allowedInstances = domains
+	allowedInstancesLoaded = true
+
+	return nil
+}
+
+// IsInstanceAllowed reports whether we federate with the instance of a url,
+// board@instance handle or domain. Blocked instances never are, with
+// federation set to allowlist only listed instances and their subdomains are.
+func IsInstanceAllowed(instance string) bool {
+	host := GetInstanceHost(instance)
+
+	if host == "" || host == GetInstanceHost(config.Domain) {
+		return true
+	}
+
+	if IsInstanceBlocked(host) {
+		return false
+	}
+
+	if config.Federation != FederationAllowlist {
+		return true
+	}
+
+	allowedInstancesMutex.RLock()
+	loaded := allowedInstancesLoaded
+	allowedInstancesMutex.RUnlock()
+
+	if !loaded {
+		if err := LoadInstanceAllows(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+
+	allowedInstancesMutex.RLock()
+	defer allowedInstancesMutex.RUnlock()
+
+	return MatchInstance(host, allowedInstances)
+}
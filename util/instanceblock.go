@@ -0,0 +1,150 @@
+package util
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anomalous69/fchannel/config"
+)
+
+type InstanceBlock struct {
+	Id      int
+	Domain  string
+	Reason  string
+	Created time.Time
+}
+
+// The block list is checked for every rendered reply link and proxied image,
+// so it is kept in memory and reloaded whenever it changes.
+var blockedInstances []string
+var blockedInstancesLoaded bool
+var blockedInstancesMutex sync.RWMutex
+
+func GetInstanceBlocks() ([]InstanceBlock, error) {
+	var list []InstanceBlock
+
+	query := `select id, domain, reason, created from instanceblock order by domain`
+	rows, err := config.DB.Query(query)
+
+	if err != nil {
+		return list, MakeError(err, "GetInstanceBlocks")
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var temp InstanceBlock
+
+		if err := rows.Scan(&temp.Id, &temp.Domain, &temp.Reason, &temp.Created); err != nil {
+			return list, MakeError(err, "GetInstanceBlocks")
+		}
+
+		list = append(list, temp)
+	}
+
+	return list, nil
+}
+
+func WriteInstanceBlock(domain string, reason string) error {
+	domain = GetInstanceHost(domain)
+
+	if domain == "" || domain == GetInstanceHost(config.Domain) {
+		return nil
+	}
+
+	query := `insert into instanceblock (domain, reason) values ($1, $2) on conflict (domain) do update set reason=$2`
+	if _, err := config.DB.Exec(query, domain, reason); err != nil {
+		return MakeError(err, "WriteInstanceBlock")
+	}
+
+	return MakeError(LoadInstanceBlocks(), "WriteInstanceBlock")
+}
+
+func DeleteInstanceBlock(id int) error {
+	query := `delete from instanceblock where id=$1`
+	if _, err := config.DB.Exec(query, id); err != nil {
+		return MakeError(err, "DeleteInstanceBlock")
+	}
+
+	return MakeError(LoadInstanceBlocks(), "DeleteInstanceBlock")
+}
+
+func LoadInstanceBlocks() error {
+	list, err := GetInstanceBlocks()
+
+	if err != nil {
+		return MakeError(err, "LoadInstanceBlocks")
+	}
+
+	var domains []string
+	for _, e := range list {
+		domains = append(domains, e.Domain)
+	}
+
+	blockedInstancesMutex.Lock()
+	defer blockedInstancesMutex.Unlock()
+
+	blockedInstances = domains
+	blockedInstancesLoaded = true
+
+	return nil
+}
+
+// IsInstanceBlocked reports whether a url, board@instance handle or domain
+// belongs to a blocked instance or one of its subdomains.
+func IsInstanceBlocked(instance string) bool {
+	host := GetInstanceHost(instance)
+
+	if host == "" {
+		return false
+	}
+
+	blockedInstancesMutex.RLock()
+	loaded := blockedInstancesLoaded
+	blockedInstancesMutex.RUnlock()
+
+	if !loaded {
+		if err := LoadInstanceBlocks(); err != nil {
+			config.Log.Println(err)
+		}
+	}
+
+	blockedInstancesMutex.RLock()
+	defer blockedInstancesMutex.RUnlock()
+
+	return MatchInstance(host, blockedInstances)
+}
+
+// MatchInstance reports whether host is one of domains or a subdomain of one.
+func MatchInstance(host string, domains []string) bool {
+	for _, e := range domains {
+		if host == e || strings.HasSuffix(host, "."+e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetInstanceHost returns the lowercase host of a url, a board@instance
+// handle or a bare domain.
+func GetInstanceHost(instance string) string {
+	host := strings.ToLower(strings.TrimSpace(instance))
+
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	} else if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return host
+}
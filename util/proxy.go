@@ -28,6 +28,10 @@ func MediaProxy(url string) string {
 		return url
 	}
 
+	if !IsInstanceAllowed(url) {
+		return "/static/notfound.png"
+	}
+
 	re = regexp.MustCompile(`(.+)?(\.onion(.+)|\.loki(.+)|\.i2p(.+))?`)
 	if re.MatchString(url) {
 		return url